@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runCheck is --check: it verifies the media directories and the storage
+// configured in cfg, prints a line per check and reports whether all
+// passed. Invalid settings already stopped the program before this runs.
+func runCheck(cfg AppConfig) bool {
+	ok := true
+	report := func(name string, err error) {
+		if err != nil {
+			ok = false
+			fmt.Printf("FAIL  %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("PASS  %s\n", name)
+	}
+
+	report("configuration", nil)
+	report("media directory "+cfg.MediaDir+" is writable", checkDirWritable(cfg.MediaDir))
+	for _, dir := range cfg.MediaDirs[1:] {
+		report("media directory "+dir+" is readable", checkDirReadable(dir))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	source, err := newMediaSource(ctx, cfg)
+	switch {
+	case err != nil:
+		report(cfg.StorageBackend+" storage", err)
+	case source == nil:
+		fmt.Println("SKIP  storage: no bucket configured")
+	default:
+		prefixes := cfg.S3Prefixes
+		if len(prefixes) == 0 {
+			prefixes = []string{""}
+		}
+		for _, prefix := range prefixes {
+			objects, err := source.List(ctx, prefix)
+			name := fmt.Sprintf("list %s/%s", source, prefix)
+			if err == nil {
+				name += fmt.Sprintf(" (%d objects)", len(objects))
+			}
+			report(name, err)
+		}
+	}
+	return ok
+}
+
+// checkDirWritable creates and removes a file in dir
+func checkDirWritable(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory")
+	}
+	f, err := os.CreateTemp(dir, ".check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
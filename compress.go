@@ -0,0 +1,81 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// gzipResponse compresses the body once the handler writes one, responses
+// without a body are passed through untouched
+type gzipResponse struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponse) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	header := g.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		g.gz = gzipWriters.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponse) Write(data []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(data)
+	}
+	return g.gz.Write(data)
+}
+
+func (g *gzipResponse) close() {
+	if g.gz != nil {
+		g.gz.Close()
+		gzipWriters.Put(g.gz)
+	}
+}
+
+func (g *gzipResponse) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// withGzip compresses /api/ responses for clients that accept it. Media is
+// left alone, videos and images are compressed already.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		response := &gzipResponse{ResponseWriter: w}
+		defer response.close()
+		next.ServeHTTP(response, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
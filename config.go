@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// settingNames lists the environment variables loadConfig and main read, in
+// the order of the help text. Credentials for the storage SDKs aren't part
+// of it.
+var settingNames = []string{
+	"MEDIA_DIR", "MEDIA_DIRS", "PORT", "STORAGE_BACKEND", "S3_BUCKET",
+	"GCS_BUCKET", "S3_REGION", "REQUIRE_S3", "S3_ENDPOINT",
+	"S3_FORCE_PATH_STYLE", "S3_PREFIX", "S3_CONCURRENCY", "S3_MAX_RETRIES",
+	"MEDIA_EXTENSIONS", "MEDIA_INCLUDE", "MEDIA_EXCLUDE", "EXCLUDE_DIRS",
+	"SPLASH_MEDIA", "INDEX_TEMPLATE", "HLS_JS", "VALIDATE_MEDIA",
+	"SYNC_INTERVAL_MINUTES", "MAX_DISK_BYTES", "MAX_FILE_BYTES",
+	"MAX_UPLOAD_BYTES", "SYNC_DELETE_ORPHANS", "SYNC_DRY_RUN",
+	"IMAGE_DURATION_SECONDS", "IDLE_IMAGE", "PLAYLIST_ORDER",
+	"UI_REFRESH_SECONDS", "MAX_PLAY_FAILURES", "FAILURE_PAUSE_SECONDS",
+	"TICKER_TEXT", "LAYOUT", "TRANSITION", "TRANSITION_MS", "PROBE_DURATION",
+	"DEDUP", "MUTED", "SINGLE_LOOP", "IMAGE_WEIGHT", "VIDEO_WEIGHT",
+	"S3_SSE_KMS_KEY", "S3_CASE_COLLISION", "S3_COMPARE", "S3_DOWNLOAD_ORDER",
+	"ACTIVE_HOURS", "SCHEDULE_ON", "SCHEDULE_OFF", "TZ", "API_TOKEN",
+	"BASIC_AUTH_USER", "BASIC_AUTH_PASS", "WEBHOOK_URL", "HEARTBEAT_URL",
+	"HEARTBEAT_SECONDS", "DEVICE_ID", "CSP_POLICY", "FRAME_OPTIONS",
+	"MEDIA_CACHE_CONTROL", "API_RATE_LIMIT", "ALLOW_ORIGINS", "TLS_CERT",
+	"TLS_KEY", "TLS_SELF_SIGNED", "INTERACTIVE", "INTERACTIVE_CONTROLS",
+	"RESOLUTION", "LOG_FORMAT", "ACCESS_LOG",
+}
+
+// addSettingFlags defines a flag on fs for each setting, named after the
+// variable in lower case with dashes, e.g. --s3-bucket=media. The returned
+// function, called after parsing, sets the variables of the flags given, so
+// they override both the environment and the --config file.
+func addSettingFlags(fs *flag.FlagSet) func() error {
+	flagNames := make(map[string]string, len(settingNames))
+	for _, name := range settingNames {
+		flagName := strings.ReplaceAll(strings.ToLower(name), "_", "-")
+		fs.String(flagName, "", "Sets "+name)
+		flagNames[flagName] = name
+	}
+	return func() error {
+		var err error
+		fs.Visit(func(f *flag.Flag) {
+			if name, ok := flagNames[f.Name]; ok && err == nil {
+				err = os.Setenv(name, f.Value.String())
+			}
+		})
+		return err
+	}
+}
+
+// applyConfigFile sets the variables from the YAML or JSON file at path that
+// aren't in the environment already, so the environment overrides the file.
+// Keys are the environment variable names, in any case, and lists are joined
+// with commas:
+//
+//	s3_bucket: signage-media
+//	s3_prefix: [lobby, promo]
+//	schedule_on: "07:00"
+//
+// It returns the variables it set. On a reload, previous holds the ones set
+// by the last call, they are replaced or unset if the file dropped them.
+func applyConfigFile(path string, previous map[string]bool) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(settings))
+	for key, value := range settings {
+		text, err := configValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if value != nil {
+			values[strings.ToUpper(key)] = text
+		}
+	}
+
+	for key := range previous {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+		}
+	}
+	applied := make(map[string]bool, len(values))
+	for key, text := range values {
+		if _, ok := os.LookupEnv(key); ok && !previous[key] {
+			continue
+		}
+		if err := os.Setenv(key, text); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		applied[key] = true
+	}
+	return applied, nil
+}
+
+func configValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			text, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, text)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		return "", fmt.Errorf("expected a value or a list, not a map")
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
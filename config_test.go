@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSettingPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("port: 9000\ns3_bucket: file\nplaylist_order: mtime\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PORT", "9100")
+	t.Setenv("S3_BUCKET", "env")
+	// Unset once the test ends, applyConfigFile sets it
+	t.Setenv("PLAYLIST_ORDER", "")
+	os.Unsetenv("PLAYLIST_ORDER")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	applySettingFlags := addSettingFlags(fs)
+	if err := fs.Parse([]string{"--port=9200"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applySettingFlags(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyConfigFile(path, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"PORT": "9200", "S3_BUCKET": "env", "PLAYLIST_ORDER": "mtime"} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
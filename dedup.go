@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// dedupSampleSize is how much of the start and end of a file is hashed, files
+// up to twice this size are hashed completely
+const dedupSampleSize = 64 << 10
+
+// contentHash hashes the size and the first and last dedupSampleSize bytes,
+// which tells different media files apart without reading them completely
+func contentHash(path string, size int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, size)
+	if size <= 2*dedupSampleSize {
+		if _, err := io.Copy(h, file); err != nil {
+			return "", err
+		}
+	} else {
+		if _, err := io.CopyN(h, file, dedupSampleSize); err != nil {
+			return "", err
+		}
+		if _, err := file.Seek(-dedupSampleSize, io.SeekEnd); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(h, file); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dedupMedia keeps the first of each set of files with the same content.
+// Files without a hash are always kept.
+func dedupMedia(files []MediaFile) []MediaFile {
+	seen := make(map[string]bool, len(files))
+	unique := make([]MediaFile, 0, len(files))
+	for _, file := range files {
+		if file.hash != "" {
+			if seen[file.hash] {
+				continue
+			}
+			seen[file.hash] = true
+		}
+		unique = append(unique, file)
+	}
+	return unique
+}
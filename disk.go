@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// evictionCandidate is a local file that may be removed to make room
+type evictionCandidate struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+// oversizedKey is an object skipped for being larger than MAX_FILE_BYTES
+type oversizedKey struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+// skipOversized drops the jobs for objects larger than MAX_FILE_BYTES, so a
+// stray huge upload can't fill the disk. The skipped keys are kept for the
+// status endpoint until the next sync.
+func (s *Server) skipOversized(jobs []downloadJob) []downloadJob {
+	limit := s.config.MaxFileBytes
+	if limit <= 0 {
+		return jobs
+	}
+
+	kept := jobs[:0]
+	var skipped []oversizedKey
+	for _, job := range jobs {
+		if job.obj.Size > limit {
+			slog.Warn("Skipping file larger than MAX_FILE_BYTES", "event", "size_skip", "key", job.obj.Key, "bytes", job.obj.Size)
+			skipped = append(skipped, oversizedKey{Key: job.obj.Key, Size: job.obj.Size})
+			continue
+		}
+		kept = append(kept, job)
+	}
+
+	s.stateMu.Lock()
+	s.oversized = skipped
+	s.stateMu.Unlock()
+	return kept
+}
+
+// makeRoom drops the jobs that can't fit under MAX_DISK_BYTES and evicts
+// the least recently played synced files in the sync target to make room for
+// the others. Uploaded and copied files can't be restored from the bucket,
+// so only files in the manifest are evicted. Files never played count as
+// used when they were modified. Evicted files are added to the result's
+// deletions and come back once they fit again, without evicting others in
+// turn; otherwise a bucket larger than the limit would be downloaded over
+// and over.
+func (s *Server) makeRoom(jobs []downloadJob, result *SyncResult) []downloadJob {
+	limit := s.config.MaxDiskBytes
+	if limit <= 0 {
+		return jobs
+	}
+
+	usage := dirSize(s.config.MediaDir)
+	replaced := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		replaced[job.localPath] = true
+	}
+
+	s.stateMu.Lock()
+	var candidates []evictionCandidate
+	for _, media := range s.currentMedia() {
+		if media.root != 0 || replaced[media.Path] {
+			continue
+		}
+		relPath, err := filepath.Rel(s.config.MediaDir, media.Path)
+		if _, synced := s.manifest.Objects[filepath.ToSlash(relPath)]; err != nil || !synced {
+			continue
+		}
+		info, err := os.Stat(media.Path)
+		if err != nil {
+			continue
+		}
+		lastUsed := s.playCounts[media.Path].LastPlayed
+		if lastUsed.IsZero() {
+			lastUsed = info.ModTime()
+		}
+		candidates = append(candidates, evictionCandidate{media.Path, info.Size(), lastUsed})
+	}
+	s.stateMu.Unlock()
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+	})
+
+	kept := jobs[:0]
+	evicted := make(map[string]string)
+	waiting := 0
+	for _, job := range jobs {
+		if job.obj.Size > limit {
+			slog.Warn("Skipping file larger than MAX_DISK_BYTES", "event", "disk_skip", "key", job.name, "bytes", job.obj.Size)
+			continue
+		}
+		needed := job.obj.Size
+		if info, err := os.Stat(job.localPath); err == nil {
+			needed -= info.Size()
+		}
+
+		// A changed object is new content, it may make room like any other
+		if etag, ok := s.evicted[job.name]; ok && etag == job.obj.ETag && usage+needed > limit {
+			slog.Debug("Evicted file doesn't fit yet", "event", "evict_wait", "key", job.name, "bytes", job.obj.Size)
+			evicted[job.name] = etag
+			waiting++
+			continue
+		}
+
+		for usage+needed > limit && len(candidates) > 0 {
+			victim := candidates[0]
+			candidates = candidates[1:]
+			if err := os.Remove(victim.path); err != nil {
+				slog.Error("Failed to evict", "event", "evict_error", "path", victim.path, "error", err)
+				continue
+			}
+			usage -= victim.size
+			relPath, _ := filepath.Rel(s.config.MediaDir, victim.path)
+			key := filepath.ToSlash(relPath)
+			slog.Info("Evicted to free disk space", "event", "evict", "key", key, "bytes", victim.size)
+			pruneEmptyDirs(filepath.Dir(victim.path), s.config.MediaDir)
+			if record, ok := s.manifest.Objects[key]; ok {
+				evicted[key] = record.ETag
+			}
+			delete(s.manifest.Objects, key)
+			result.Deleted = append(result.Deleted, key)
+		}
+		if usage+needed > limit {
+			slog.Warn("Not enough disk space, skipping", "event", "disk_skip", "key", job.name, "bytes", job.obj.Size)
+			continue
+		}
+		usage += needed
+		kept = append(kept, job)
+	}
+
+	if waiting > 0 && !s.noRoom {
+		slog.Warn("The synced files don't fit under MAX_DISK_BYTES, evicted files stay off disk until there is room",
+			"event", "disk_full", "waiting", waiting, "limit", limit)
+	}
+	s.noRoom = waiting > 0
+	s.evicted = evicted
+	return kept
+}
+
+// dirSize returns the total size of the regular files below dir, leaving out
+// the server's state files and partial downloads. Those are small or end
+// up replaced by the download's full size, which makeRoom already counts.
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if name := info.Name(); !stateFileNames[name] && name != manifestFileName && !strings.HasSuffix(name, ".tmp") {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// diskUsage is the size of a filesystem in bytes, as reported by diskSpace
+// for the platform
+type diskUsage struct {
+	Total uint64
+	Used  uint64
+	Free  uint64
+}
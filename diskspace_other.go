@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func diskSpace(path string) (diskUsage, error) {
+	return diskUsage{}, errors.New("disk space is not reported on this platform")
+}
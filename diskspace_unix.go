@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// diskSpace reports the filesystem holding path. Free only counts the space
+// available to unprivileged processes, as the sync sees it.
+func diskSpace(path string) (diskUsage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return diskUsage{}, err
+	}
+	blockSize := uint64(stat.Bsize)
+	return diskUsage{
+		Total: uint64(stat.Blocks) * blockSize,
+		Used:  (uint64(stat.Blocks) - uint64(stat.Bfree)) * blockSize,
+		Free:  uint64(stat.Bavail) * blockSize,
+	}, nil
+}
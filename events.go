@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const wsPingInterval = 30 * time.Second
+
+// Event types sent to the displays
+const (
+	eventMediaChanged  = "media_changed"
+	eventTickerChanged = "ticker_changed"
+	// A priority item was set or cleared
+	eventPriorityChanged = "priority_changed"
+	eventVolumeChanged   = "volume_changed"
+	eventPauseChanged    = "pause_changed"
+)
+
+// The default origin check only accepts the page served by this server
+var upgrader = websocket.Upgrader{}
+
+// mediaEvents fans out media list and ticker changes to the connected
+// displays
+type mediaEvents struct {
+	mu          sync.Mutex
+	subscribers map[chan string]bool
+	closed      bool
+}
+
+func newMediaEvents() *mediaEvents {
+	return &mediaEvents{subscribers: make(map[chan string]bool)}
+}
+
+// subscribe returns a channel that receives the type of each event, and is
+// closed when the server shuts down
+func (e *mediaEvents) subscribe() (chan string, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ch := make(chan string, 8)
+	if e.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	e.subscribers[ch] = true
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.subscribers[ch] {
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish notifies every subscriber without blocking, events for a
+// subscriber that is that far behind are dropped
+func (e *mediaEvents) publish(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// close disconnects all subscribers
+func (e *mediaEvents) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for ch := range e.subscribers {
+		delete(e.subscribers, ch)
+		close(ch)
+	}
+}
+
+// handleWebSocket pushes a message to the display whenever the media list or
+// the ticker changes, so it doesn't have to wait for the next poll
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already replied with an error
+	}
+	defer conn.Close()
+
+	changes, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	// The display never sends anything, reading only detects the close
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case event, ok := <-changes:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err = conn.WriteJSON(map[string]string{"type": event})
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		case <-gone:
+			return
+		}
+		if err != nil {
+			log.Printf("WebSocket client %s disconnected: %v", r.RemoteAddr, err)
+			return
+		}
+	}
+}
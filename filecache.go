@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// fileCache remembers a value computed from a file's content until the
+// file's size or modification time changes, so rescans don't read every
+// file again
+type fileCache[T any] struct {
+	mu      sync.Mutex
+	entries map[string]cachedValue[T]
+}
+
+type cachedValue[T any] struct {
+	size    int64
+	modTime time.Time
+	value   T
+}
+
+func newFileCache[T any]() *fileCache[T] {
+	return &fileCache[T]{entries: make(map[string]cachedValue[T])}
+}
+
+// get returns the cached value for the file at path or computes it. A
+// failure yields the zero value, which is cached as well so a file that
+// can't be read isn't retried on every scan.
+func (c *fileCache[T]) get(path string, info os.FileInfo, compute func(path string, size int64) (T, error)) T {
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.value
+	}
+
+	value, err := compute(path, info.Size())
+	if err != nil {
+		var zero T
+		value = zero
+	}
+	c.mu.Lock()
+	c.entries[path] = cachedValue[T]{size: info.Size(), modTime: info.ModTime(), value: value}
+	c.mu.Unlock()
+	return value
+}
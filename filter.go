@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// nameFilter holds the MEDIA_INCLUDE and MEDIA_EXCLUDE glob patterns. They
+// match a file's path relative to its media directory, the base name for
+// patterns without a slash, or any of its parent folders, so "draft_*"
+// hides drafts everywhere and "wip" a whole folder. The EXCLUDE_DIRS
+// patterns match folders the same way, those aren't even walked.
+type nameFilter struct {
+	include []string
+	exclude []string
+	dirs    []string
+}
+
+func parseNameFilter(include, exclude, dirs string) (nameFilter, error) {
+	filter := nameFilter{include: splitList(include), exclude: splitList(exclude), dirs: splitList(dirs)}
+	for _, pattern := range slices.Concat(filter.include, filter.exclude, filter.dirs) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nameFilter{}, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return filter, nil
+}
+
+// allows reports whether the file at relPath, slash separated, is shown.
+// Without include patterns every file not excluded is, other than dotfiles,
+// partial downloads and files in skipped folders.
+func (f nameFilter) allows(relPath string) bool {
+	if strings.HasPrefix(path.Base(relPath), ".") || strings.HasSuffix(relPath, ".tmp") {
+		return false
+	}
+	if parent := path.Dir(relPath); parent != "." && f.skipsDir(parent) {
+		return false
+	}
+	if len(f.include) > 0 && !matchAny(f.include, relPath) {
+		return false
+	}
+	return !matchAny(f.exclude, relPath)
+}
+
+// skipsDir reports whether the folder at relPath, or one of its parents,
+// matches EXCLUDE_DIRS
+func (f nameFilter) skipsDir(relPath string) bool {
+	return matchAny(f.dirs, relPath)
+}
+
+func matchAny(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		candidate := relPath
+		for {
+			name := candidate
+			if !strings.Contains(pattern, "/") {
+				name = path.Base(candidate)
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			parent := path.Dir(candidate)
+			if parent == "." || parent == "/" || parent == candidate {
+				break
+			}
+			candidate = parent
+		}
+	}
+	return false
+}
+
+// withMediaFilter hides the files the filter excludes from /media/, the
+// request path is relative to the media directory, after its index when
+// there are several
+func withMediaFilter(filter nameFilter, multipleDirs bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		relPath := path.Clean("/" + r.URL.Path)[1:]
+		if multipleDirs {
+			_, relPath, _ = strings.Cut(relPath, "/")
+		}
+		if relPath != "" && !filter.allows(relPath) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
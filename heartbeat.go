@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// heartbeat is what each player reports to HEARTBEAT_URL, so a fleet can be
+// watched from one place without polling every device
+type heartbeat struct {
+	DeviceID   string      `json:"device_id"`
+	Version    string      `json:"version"`
+	Time       time.Time   `json:"time"`
+	MediaCount int         `json:"media_count"`
+	LastSync   *SyncResult `json:"last_sync"`
+	LastError  string      `json:"last_error,omitempty"`
+	NowPlaying *nowPlaying `json:"now_playing"`
+	DiskFree   *uint64     `json:"disk_free_bytes,omitempty"`
+}
+
+// heartbeatLoop posts a heartbeat right away and then every HEARTBEAT_SECONDS
+// until ctx is done. Failures are only logged, playback doesn't depend on it.
+func (s *Server) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.config.HeartbeatEvery)
+	defer ticker.Stop()
+
+	for {
+		if err := s.sendHeartbeat(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Heartbeat failed: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) sendHeartbeat(ctx context.Context) error {
+	s.stateMu.Lock()
+	report := heartbeat{
+		DeviceID:   s.config.DeviceID,
+		Version:    Version,
+		Time:       time.Now(),
+		MediaCount: len(s.currentMedia()),
+		LastSync:   s.syncState.LastSync,
+		LastError:  s.syncState.LastError,
+		NowPlaying: s.nowPlaying,
+	}
+	s.stateMu.Unlock()
+	if usage, err := diskSpace(s.config.MediaDir); err == nil {
+		report.DiskFree = &usage.Free
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.HeartbeatURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dropVariantPlaylists removes the HLS playlists another playlist in the list
+// refers to, the variants of a master playlist would otherwise play as
+// separate entries
+func dropVariantPlaylists(files []MediaFile) []MediaFile {
+	variants := make(map[string]bool)
+	for _, file := range files {
+		if file.Type == "hls" {
+			for _, uri := range playlistURIs(file.Path) {
+				variants[filepath.Join(filepath.Dir(file.Path), filepath.FromSlash(uri))] = true
+			}
+		}
+	}
+	if len(variants) == 0 {
+		return files
+	}
+
+	kept := files[:0]
+	for _, file := range files {
+		if !variants[file.Path] {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// playlistURIs lists the relative .m3u8 URIs in the playlist at path, only
+// master playlists have any
+func playlistURIs(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var uris []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.Contains(line, "://") {
+			continue
+		}
+		uri, _, _ := strings.Cut(line, "?")
+		if strings.EqualFold(filepath.Ext(uri), ".m3u8") {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// layoutRegions lists the screen regions of each LAYOUT. The first region
+// plays every file outside the other regions' folders.
+var layoutRegions = map[string][]string{
+	"fullscreen": nil,
+	"main-side":  {"main", "side"},
+}
+
+func validateLayout(layout string) error {
+	if _, ok := layoutRegions[layout]; !ok {
+		return fmt.Errorf("unknown layout %q, expected fullscreen or main-side", layout)
+	}
+	return nil
+}
+
+// regionOf returns the region a file, by its slash separated path relative
+// to its media directory, plays in. That is the region named like its top
+// folder, e.g. side/menu.png, the first region otherwise and none without
+// regions.
+func regionOf(layout, relPath string) string {
+	regions := layoutRegions[layout]
+	if len(regions) == 0 {
+		return ""
+	}
+	folder, _, nested := strings.Cut(relPath, "/")
+	for _, region := range regions {
+		if nested && strings.EqualFold(folder, region) {
+			return region
+		}
+	}
+	return regions[0]
+}
+
+// regionMedia returns the files of the region
+func regionMedia(files []MediaFile, region string) []MediaFile {
+	var filtered []MediaFile
+	for _, media := range files {
+		if media.Region == region {
+			filtered = append(filtered, media)
+		}
+	}
+	return filtered
+}
+
+// groupByRegion splits the playlist into the layout's regions, each keeping
+// the playlist order
+func groupByRegion(layout string, files []MediaFile) map[string][]MediaFile {
+	groups := make(map[string][]MediaFile)
+	for _, region := range layoutRegions[layout] {
+		groups[region] = []MediaFile{}
+	}
+	for _, media := range files {
+		groups[media.Region] = append(groups[media.Region], media)
+	}
+	return groups
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// setupLogging installs the default slog logger. The standard log package
+// is routed through it too, so in JSON mode every line is a JSON record.
+func setupLogging(format string) error {
+	switch format {
+	case "", "text":
+		// Keep the log package's plain output, slog writes through it
+		return nil
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+}
+
+// accessRecorder captures the status and size of a response for the
+// access log
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *accessRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *accessRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps http.ServeContent's sendfile path for large files
+func (r *accessRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := io.Copy(r.ResponseWriter, src)
+	r.bytes += n
+	return n, err
+}
+
+func (r *accessRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// withAccessLog logs every request once the response is complete, so a
+// stalled display shows up as missing or short media transfers
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &accessRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		attrs := []any{"event", "access", "method", r.Method, "path", r.URL.Path,
+			"status", recorder.status, "bytes", recorder.bytes,
+			"duration_ms", time.Since(started).Milliseconds(), "remote", r.RemoteAddr}
+		if value := r.Header.Get("Range"); value != "" {
+			attrs = append(attrs, "range", value)
+		}
+		slog.Info("Served", attrs...)
+	})
+}
@@ -1,54 +1,196 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"html/template"
 	"io"
 	"log"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"slices"
-	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	// Zone names in TZ work even where the system has no zoneinfo, as in
+	// minimal containers
+	_ "time/tzdata"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Version is set during build time
 var Version = "dev"
 
 type AppConfig struct {
-	MediaDir     string
-	S3Bucket     string
-	S3Region     string
-	SyncInterval time.Duration
-	Port         string
+	MediaDir       string
+	MediaDirs      []string
+	StorageBackend string
+	S3Bucket       string
+	GCSBucket      string
+	S3Region       string
+	S3Prefixes     []string
+	S3Endpoint     string
+	S3PathStyle    bool
+	S3KMSKey       string
+	RequireSync    bool
+	S3Concurrency  int
+	S3MaxRetries   int
+	SyncDryRun     bool
+	DeleteOrphans  bool
+	MaxDiskBytes   int64
+	MaxFileBytes   int64
+	MaxUploadBytes int64
+	SyncInterval   time.Duration
+	Port           string
+	CaseCollision  string
+	SyncCompare    string
+	DownloadOrder  string
+	ActiveHours    string
+	ScheduleOn     string
+	ScheduleOff    string
+	APIToken       string
+	BasicAuthUser  string
+	BasicAuthPass  string
+	WebhookURL     string
+	HeartbeatURL   string
+	HeartbeatEvery time.Duration
+	DeviceID       string
+	CSP            string
+	FrameOptions   string
+	CacheControl   string
+	TLSCert        string
+	TLSKey         string
+	TLSSelfSigned  bool
+	AllowOrigins   []string
+	APIRateLimit   int
+
+	Interactive         bool
+	InteractiveControls bool
+	Resolution          int
+	ImageDuration       time.Duration
+	PlaylistOrder       string
+	ImageWeight         int
+	SingleLoop          bool
+	UIRefresh           time.Duration
+	MaxFailures         int
+	FailurePause        time.Duration
+	Transition          string
+	Layout              string
+	TransitionTime      time.Duration
+	Dedup               bool
+	ProbeDuration       bool
+	VideoWeight         int
+	ValidateMedia       bool
+	IdleImage           string
+	SplashMedia         string
+	TickerText          string
+	HLSPlayer           string
+	IndexTemplate       string
+	Muted               bool
+	AccessLog           bool
+	// MediaExtensions maps each scanned extension, e.g. ".mp4", to its
+	// media type
+	MediaExtensions map[string]string
+	MediaFilter     nameFilter
 }
 
 type MediaFile struct {
 	Name string `json:"name"`
 	Path string `json:"path"`
 	URL  string `json:"url"`
+	// Type is "video", "image" or "hls" for an HLS playlist
+	Type string `json:"type"`
+	// Resolution is the vertical resolution taken from a rendition suffix
+	// in the file name, e.g. foo_1080.mp4, or 0 if there is none
+	Resolution int `json:"resolution,omitempty"`
+	// MaxDuration is how many seconds the file is shown at most, taken
+	// from playlist.json, or 0 to play videos to the end
+	MaxDuration int       `json:"max_duration,omitempty"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	// Duration is the length of a video in seconds, with PROBE_DURATION
+	Duration *float64 `json:"duration"`
+	// Volume scales the display volume for this file, from playlist.json
+	Volume *float64 `json:"volume,omitempty"`
+	// Source is the S3_PREFIX folder the file is synced from when several
+	// are configured
+	Source string `json:"source,omitempty"`
+	// Region is the screen region the file plays in with a LAYOUT other
+	// than fullscreen
+	Region string `json:"region,omitempty"`
+
+	root int
+	// schedule limits when the file is shown, from playlist.json
+	schedule *Schedule
+	excluded bool
+	weight   int
+	// hash identifies the content when DEDUP is enabled
+	hash string
 }
 
 type Server struct {
-	config    AppConfig
-	s3Client  *s3.Client
+	config   AppConfig
+	source   MediaSource
+	schedule Schedule
+	syncMu   sync.Mutex
+	manifest *syncManifest
+	// evicted maps the keys makeRoom removed to their ETag, noRoom is set
+	// while some of them can't come back. Only syncs use them, under syncMu.
+	evicted  map[string]string
+	noRoom   bool
+	events   *mediaEvents
+	reloaded chan struct{}
+	page     *template.Template
+
+	// mediaList is replaced as a whole by scanMedia, never modified in place
+	mediaMu   sync.RWMutex
 	mediaList []MediaFile
+	// listOrder is the order mediaList is in: PLAYLIST_ORDER, "manual" for
+	// the one set through /api/playlist or "playlist.json"
+	listOrder string
+
+	stateMu    sync.Mutex
+	syncState  syncState
+	ticker     string
+	nowPlaying *nowPlaying
+	// playCounts counts the reports from the display per file path,
+	// statsDirty is set until they are saved
+	playCounts map[string]playStat
+	statsDirty bool
+	priority   *priorityItem
+	volume     float64
+	paused     bool
+	order      []string // set through /api/playlist
+	sortOrder  string   // PLAYLIST_ORDER, reloaded on SIGHUP
+	syncEvery  time.Duration
+	failures   map[string]*keyFailure
+	oversized  []oversizedKey
+	hashes     *fileCache[string]
+	durations  *fileCache[float64]
 }
 
 func main() {
 	var (
 		showVersion = flag.Bool("version", false, "Show version information")
 		showHelp    = flag.Bool("help", false, "Show help information")
+		configFile  = flag.String("config", "", "YAML or JSON file with settings, environment variables and flags override it")
+		check       = flag.Bool("check", false, "Check the configuration, media directory and storage access, then exit")
 	)
+	applySettingFlags := addSettingFlags(flag.CommandLine)
 	flag.Parse()
 
 	if *showVersion {
@@ -61,447 +203,1272 @@ func main() {
 		fmt.Println("A lightweight digital signage application")
 		fmt.Println("\nUsage:")
 		fmt.Println("  digital-signage [options]")
+		fmt.Println("  digital-signage install-service [--format systemd|launchd] [--install]")
 		fmt.Println("\nOptions:")
 		fmt.Println("  --version    Show version information")
 		fmt.Println("  --help       Show this help message")
+		fmt.Println("  --check      Check the configuration, that MEDIA_DIR is writable and the bucket can be listed,")
+		fmt.Println("               then exit non-zero if anything failed")
+		fmt.Println("  --config     YAML or JSON file setting the variables below by name, e.g. s3_bucket: media;")
+		fmt.Println("               environment variables and flags override it. SIGHUP reloads it, applying SYNC_INTERVAL_MINUTES,")
+		fmt.Println("               PLAYLIST_ORDER and TICKER_TEXT without a restart")
+		fmt.Println("  --<name>     Sets any variable below, named in lower case with dashes, e.g. --s3-bucket=media;")
+		fmt.Println("               overrides the environment and --config")
 		fmt.Println("\nEnvironment Variables:")
-		fmt.Println("  MEDIA_DIR              Directory containing video files (default: ./media)")
+		fmt.Println("  MEDIA_DIR              Directory containing video and image files (default: ./media)")
+		fmt.Println("  MEDIA_DIRS             Media directories to play from, separated by commas or colons like PATH; the first")
+		fmt.Println("                         one is the sync target unless MEDIA_DIR is set (optional)")
 		fmt.Println("  PORT                   HTTP server port (default: 8080)")
+		fmt.Println("  STORAGE_BACKEND        Storage to sync media from: s3, gcs (default: s3)")
 		fmt.Println("  S3_BUCKET              S3 bucket name for sync (optional)")
-		fmt.Println("  S3_REGION              AWS region (default: us-east-1)")
+		fmt.Println("  GCS_BUCKET             Google Cloud Storage bucket name when STORAGE_BACKEND=gcs")
+		fmt.Println("  S3_REGION              AWS region (default: sa-east-1)")
+		fmt.Println("  REQUIRE_S3             Exit at startup if the sync storage can't be initialized, any backend (default: false)")
+		fmt.Println("  S3_ENDPOINT            Endpoint of S3-compatible storage such as MinIO, e.g. http://minio:9000 (optional)")
+		fmt.Println("  S3_FORCE_PATH_STYLE    Address the bucket in the path instead of the host name, needed by MinIO (default: false)")
+		fmt.Println("  S3_PREFIX              Only sync keys under this folder, stored relative to MEDIA_DIR; several comma-separated folders are kept as subfolders and played in turn (optional, any backend)")
+		fmt.Println("  S3_CONCURRENCY         Number of parallel downloads (default: 4, any backend)")
+		fmt.Println("  S3_MAX_RETRIES         Retries for failed listings and downloads (default: 3, any backend)")
+		fmt.Println("  MEDIA_EXTENSIONS       Comma-separated file extensions to play (default: mp4,avi,mov,mkv,webm,m4v,3gp,jpg,jpeg,png,gif,webp,")
+		fmt.Println("                         and m3u8 with HLS_JS)")
+		fmt.Println("  MEDIA_INCLUDE          Comma-separated glob patterns, only matching files are played, e.g. campaign/*,*.mp4 (optional)")
+		fmt.Println("  MEDIA_EXCLUDE          Comma-separated glob patterns of files never played or served, e.g. draft_*,wip (optional)")
+		fmt.Println("  EXCLUDE_DIRS           Comma-separated glob patterns of folders never scanned or served, e.g. .*,thumbs,")
+		fmt.Println("                         \"off\" scans every folder. Dotfiles are always skipped (default: .*)")
+		fmt.Println("  SPLASH_MEDIA           Video or image played once at startup before the playlist, skipped if missing (optional)")
+		fmt.Println("  INDEX_TEMPLATE         html/template file replacing the built-in display page (optional)")
+		fmt.Println("  HLS_JS                 Local hls.min.js used to play .m3u8 streams where the browser can't natively (optional)")
+		fmt.Println("  VALIDATE_MEDIA         Check downloads have a header matching the extension, empty files are always rejected (default: true)")
 		fmt.Println("  SYNC_INTERVAL_MINUTES  S3 sync interval in minutes (default: 15)")
+		fmt.Println("  MAX_DISK_BYTES         Disk space synced media may use, least recently played files are evicted (default: unlimited)")
+		fmt.Println("  MAX_FILE_BYTES         Objects larger than this are not downloaded, listed in /api/status (default: unlimited)")
+		fmt.Println("  MAX_UPLOAD_BYTES       Largest file accepted by POST /api/upload (default: 1073741824)")
+		fmt.Println("  SYNC_DELETE_ORPHANS    Also delete local files the sync didn't download when missing remotely (default: false)")
+		fmt.Println("  SYNC_DRY_RUN           Only log what a sync would download and delete (default: false)")
+		fmt.Println("  IMAGE_DURATION_SECONDS How long each image is shown (default: 10)")
+		fmt.Println("  IDLE_IMAGE             Image shown while there is no media, \"off\" disables (default: MEDIA_DIR/idle.png)")
+		fmt.Println("  PLAYLIST_ORDER         Playback order: name, natural (clip2 before clip10), shuffle, mtime, mtime-desc (default: name); a playlist.json in MEDIA_DIR overrides it")
+		fmt.Println("  UI_REFRESH_SECONDS     How often the display reloads the media list, at least 10 (default: 300)")
+		fmt.Println("  MAX_PLAY_FAILURES      Files failing to play in a row before the display pauses (default: 5)")
+		fmt.Println("  FAILURE_PAUSE_SECONDS  How long the display pauses after MAX_PLAY_FAILURES (default: 60)")
+		fmt.Println("  TICKER_TEXT            Scrolling message set at startup and on reload, replacing the one set through /api/ticker (optional)")
+		fmt.Println("  LAYOUT                 Screen layout: fullscreen, or main-side to play side/ in a sidebar next to the")
+		fmt.Println("                         other files (default: fullscreen)")
+		fmt.Println("  TRANSITION             Effect between files: none or fade (default: none)")
+		fmt.Println("  TRANSITION_MS          Length of the transition in milliseconds (default: 500)")
+		fmt.Println("  PROBE_DURATION         Report video durations, uses ffprobe for non-MP4 files if installed (default: false)")
+		fmt.Println("  DEDUP                  Play files with identical content only once (default: false)")
+		fmt.Println("  MUTED                  Play videos without sound, unmuted autoplay needs --autoplay-policy=no-user-gesture-required as in kiosk.sh (default: true)")
+		fmt.Println("  SINGLE_LOOP            Loop the first file forever instead of rotating (default: false)")
+		fmt.Println("  IMAGE_WEIGHT           Times each image appears per rotation (default: 1)")
+		fmt.Println("  VIDEO_WEIGHT           Times each video appears per rotation (default: 1)")
+		fmt.Println("  S3_SSE_KMS_KEY         KMS key ID, ARN or alias objects must be encrypted with, others fail to download (optional)")
+		fmt.Println("  S3_CASE_COLLISION      Key kept when S3 keys differ only by case: first, last (default: first)")
+		fmt.Println("  S3_COMPARE             How a replaced object is detected: etag, or modified to download it when its")
+		fmt.Println("                         LastModified is newer than the downloaded copy (default: etag)")
+		fmt.Println("  S3_DOWNLOAD_ORDER      Order a sync downloads objects in, and keeps them when MAX_DISK_BYTES is")
+		fmt.Println("                         reached: key, or newest for the most recently modified first (default: key)")
+		fmt.Println("  ACTIVE_HOURS           Playback schedule, e.g. \"Mon-Fri 08:00-20:00; Sat 10:00-14:00\" (default: always)")
+		fmt.Println("  SCHEDULE_ON            Daily time the screen turns on, HH:MM, used with SCHEDULE_OFF instead of ACTIVE_HOURS")
+		fmt.Println("  SCHEDULE_OFF           Daily time the screen goes black, HH:MM")
+		fmt.Println("  TZ                     Timezone for log timestamps and schedules, e.g. Europe/Berlin (default: system timezone)")
+		fmt.Println("  API_TOKEN              Bearer token required by /api/ endpoints other than those the display reads (optional)")
+		fmt.Println("  BASIC_AUTH_USER        Basic auth user accepted by the same /api/ endpoints, together with BASIC_AUTH_PASS (optional)")
+		fmt.Println("  BASIC_AUTH_PASS        Basic auth password (optional)")
+		fmt.Println("  WEBHOOK_URL            URL that receives a JSON summary of each sync that changed files, retried twice (optional)")
+		fmt.Println("  HEARTBEAT_URL          URL the player posts its status to periodically, for fleet monitoring (optional)")
+		fmt.Println("  HEARTBEAT_SECONDS      Seconds between heartbeats (default: 60)")
+		fmt.Println("  DEVICE_ID              Device ID sent with each heartbeat (default: hostname)")
+		fmt.Println("  CSP_POLICY             Content-Security-Policy, {nonce} is replaced per request, \"off\" disables (default: strict)")
+		fmt.Println("  FRAME_OPTIONS          X-Frame-Options header, \"off\" disables (default: DENY)")
+		fmt.Println("  MEDIA_CACHE_CONTROL    Cache-Control header of /media/ responses, \"off\" disables (default: public, max-age=3600)")
+		fmt.Println("  API_RATE_LIMIT         Requests per second each client may make to /api/, bursts of twice that (default: unlimited)")
+		fmt.Println("  ALLOW_ORIGINS          Comma-separated origins allowed to call the API from a browser, or * (default: none)")
+		fmt.Println("  TLS_CERT               TLS certificate file, serves HTTPS together with TLS_KEY (optional)")
+		fmt.Println("  TLS_KEY                TLS private key file (optional)")
+		fmt.Println("  TLS_SELF_SIGNED        Serve HTTPS with a generated self-signed certificate (default: false)")
+		fmt.Println("  INTERACTIVE            Advance on tap/click and show the cursor (default: false)")
+		fmt.Println("  INTERACTIVE_CONTROLS   Show on-screen playback controls in interactive mode (default: false)")
+		fmt.Println("  RESOLUTION             Display resolution used to pick renditions, e.g. 1080p, 4k (default: reported by player)")
+		fmt.Println("  LOG_FORMAT             Log output format: text, json (default: text)")
+		fmt.Println("  ACCESS_LOG             Log each /media/ request with its status, bytes and duration (default: true)")
 		fmt.Println("  AWS_ACCESS_KEY_ID      AWS access key (optional)")
 		fmt.Println("  AWS_SECRET_ACCESS_KEY  AWS secret key (optional)")
 		return
 	}
 
-	appconfig := AppConfig{
-		MediaDir:     getEnv("MEDIA_DIR", "./media"),
-		S3Bucket:     getEnv("S3_BUCKET", ""),
-		S3Region:     getEnv("S3_REGION", "sa-east-1"),
-		SyncInterval: time.Duration(getEnvInt("SYNC_INTERVAL_MINUTES", 15)) * time.Minute,
-		Port:         getEnv("PORT", "8080"),
+	if err := applySettingFlags(); err != nil {
+		log.Fatalf("Invalid flag: %v", err)
+	}
+	var fileKeys map[string]bool
+	if *configFile != "" {
+		keys, err := applyConfigFile(*configFile, nil)
+		if err != nil {
+			log.Fatalf("Invalid --config file: %v", err)
+		}
+		fileKeys = keys
+	}
+	appconfig := loadConfig()
+	if err := setupLogging(strings.ToLower(getEnv("LOG_FORMAT", "text"))); err != nil {
+		log.Fatalf("Invalid LOG_FORMAT: %v", err)
+	}
+	// The runtime silently falls back to UTC for an unknown TZ
+	if tz := os.Getenv("TZ"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Fatalf("Invalid TZ: %v", err)
+		}
+		time.Local = location
+	}
+	if value := os.Getenv("RESOLUTION"); value != "" {
+		resolution, err := parseResolution(value)
+		if err != nil {
+			log.Fatalf("Invalid RESOLUTION: %v", err)
+		}
+		appconfig.Resolution = resolution
+	}
+	// Chromium can't play HLS by itself, streams are only picked up by
+	// default when hls.js is served
+	mediaExtensions := defaultMediaExtensions
+	if appconfig.HLSPlayer != "" {
+		mediaExtensions += ",m3u8"
+	}
+	extensions, err := parseMediaExtensions(getEnv("MEDIA_EXTENSIONS", mediaExtensions))
+	if err != nil {
+		log.Fatalf("Invalid MEDIA_EXTENSIONS: %v", err)
+	}
+	appconfig.MediaExtensions = extensions
+	filter, err := parseNameFilter(getEnv("MEDIA_INCLUDE", ""), getEnv("MEDIA_EXCLUDE", ""), getEnvOptional("EXCLUDE_DIRS", ".*"))
+	if err != nil {
+		log.Fatalf("Invalid MEDIA_INCLUDE/MEDIA_EXCLUDE/EXCLUDE_DIRS: %v", err)
+	}
+	appconfig.MediaFilter = filter
+
+	if flag.Arg(0) == "install-service" {
+		if err := runInstallService(*configFile, fileKeys, flag.Args()[1:]); err != nil {
+			log.Fatalf("install-service: %v", err)
+		}
+		return
 	}
 
 	// Create media directory if it doesn't exist
 	if err := os.MkdirAll(appconfig.MediaDir, 0755); err != nil {
 		log.Fatalf("Failed to create media directory: %v", err)
 	}
+	for _, dir := range appconfig.MediaDirs[1:] {
+		if _, err := os.Stat(dir); err != nil {
+			log.Printf("Warning: media directory %s is not accessible: %v", dir, err)
+		}
+	}
 
-	server := &Server{config: appconfig}
+	if (appconfig.BasicAuthUser == "") != (appconfig.BasicAuthPass == "") {
+		log.Fatalf("Invalid BASIC_AUTH_USER/BASIC_AUTH_PASS: both must be set")
+	}
 
-	// Initialize S3 client if bucket is configured
-	if appconfig.S3Bucket != "" {
-		ctx := context.Background()
-		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(appconfig.S3Region))
+	if err := validatePlaylistOrder(appconfig.PlaylistOrder); err != nil {
+		log.Fatalf("Invalid PLAYLIST_ORDER: %v", err)
+	}
+	if err := validateLayout(appconfig.Layout); err != nil {
+		log.Fatalf("Invalid LAYOUT: %v", err)
+	}
+	if appconfig.Transition != "none" && appconfig.Transition != "fade" {
+		log.Fatalf("Invalid TRANSITION: unknown transition %q, expected none or fade", appconfig.Transition)
+	}
+	if appconfig.DownloadOrder != "key" && appconfig.DownloadOrder != "newest" {
+		log.Fatalf("Invalid S3_DOWNLOAD_ORDER: unknown order %q, expected key or newest", appconfig.DownloadOrder)
+	}
+
+	if appconfig.ScheduleOn != "" || appconfig.ScheduleOff != "" {
+		spec, err := dailySchedule(appconfig)
 		if err != nil {
-			log.Printf("Failed to load S3 config: %v", err)
-		} else {
-			server.s3Client = s3.NewFromConfig(cfg)
-			log.Println("S3 sync enabled")
+			log.Fatalf("Invalid SCHEDULE_ON/SCHEDULE_OFF: %v", err)
 		}
+		appconfig.ActiveHours = spec
+	}
+	schedule, err := parseSchedule(appconfig.ActiveHours)
+	if err != nil {
+		log.Fatalf("Invalid ACTIVE_HOURS: %v", err)
+	}
+
+	page, err := loadIndexTemplate(appconfig.IndexTemplate)
+	if err != nil {
+		log.Fatalf("Invalid INDEX_TEMPLATE: %v", err)
+	}
+
+	if *check {
+		if !runCheck(appconfig) {
+			os.Exit(1)
+		}
+		return
 	}
 
+	playCounts, err := loadPlayStats(appconfig.MediaDir)
+	if err != nil {
+		log.Printf("Failed to load play stats: %v", err)
+	}
+
+	server := &Server{
+		config:     appconfig,
+		schedule:   schedule,
+		events:     newMediaEvents(),
+		reloaded:   make(chan struct{}, 1),
+		sortOrder:  appconfig.PlaylistOrder,
+		syncEvery:  appconfig.SyncInterval,
+		page:       page,
+		playCounts: playCounts,
+		failures:   make(map[string]*keyFailure),
+		volume:     1,
+		hashes:     newFileCache[string](),
+		durations:  newFileCache[float64](),
+	}
+
+	if appconfig.StorageBackend == "s3" && appconfig.S3Bucket != "" {
+		if strings.TrimSpace(appconfig.S3Region) == "" {
+			log.Fatalf("Invalid S3_REGION: a region is required when S3_BUCKET is set")
+		}
+		log.Printf("S3 region: %s", appconfig.S3Region)
+	}
+
+	// Initialize the storage backend if a bucket is configured
+	source, err := newMediaSource(context.Background(), appconfig)
+	if err != nil {
+		if appconfig.RequireSync {
+			log.Fatalf("Failed to initialize %s storage: %v", appconfig.StorageBackend, err)
+		}
+		log.Printf("Failed to initialize %s storage: %v", appconfig.StorageBackend, err)
+	} else if source == nil && appconfig.RequireSync {
+		log.Fatalf("REQUIRE_S3 is set but no bucket is configured")
+	} else if source != nil {
+		manifest, err := loadManifest(appconfig.MediaDir)
+		if err != nil {
+			log.Printf("Failed to load sync manifest, starting fresh: %v", err)
+		}
+		server.manifest = manifest
+		server.source = source
+		log.Printf("Sync enabled from %s", source)
+	}
+
+	ticker, err := loadTicker(appconfig.MediaDir)
+	if err != nil {
+		log.Printf("Failed to load ticker message: %v", err)
+	}
+	server.ticker = ticker
+	if appconfig.TickerText != "" {
+		if err := server.setTicker(appconfig.TickerText); err != nil {
+			log.Printf("Failed to save ticker message: %v", err)
+		}
+	}
+
+	// Report the previous run's syncs until the first one completes
+	state, err := loadSyncState(appconfig.MediaDir)
+	if err != nil {
+		log.Printf("Failed to load sync state: %v", err)
+	}
+	server.syncState = state
+
+	order, err := loadPlaylistOrder(appconfig.MediaDir)
+	if err != nil {
+		log.Printf("Failed to load playlist order: %v", err)
+	}
+	server.order = order
+
 	// Initial media scan
 	server.scanMedia()
 
-	// Start background sync if S3 is configured
-	if server.s3Client != nil {
-		go server.syncLoop()
+	// Stop serving and syncing on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start background sync if storage is configured
+	syncDone := make(chan struct{})
+	if server.source != nil {
+		go func() {
+			defer close(syncDone)
+			server.syncLoop(ctx)
+		}()
+	} else {
+		close(syncDone)
+	}
+	go server.watchMedia(ctx)
+	go server.reloadOnHangup(ctx, *configFile, fileKeys)
+	go server.playStatsLoop(ctx)
+	if appconfig.HeartbeatURL != "" {
+		go server.heartbeatLoop(ctx)
 	}
 
-	// Setup HTTP routes
-	http.HandleFunc("/", server.handleIndex)
-	http.HandleFunc("/api/media", server.handleMediaAPI)
-	http.Handle("/media/", http.StripPrefix("/media/", http.FileServer(http.Dir(appconfig.MediaDir))))
+	// Setup HTTP routes on a mux of our own, libraries register debug
+	// handlers on the default one. Handlers for several methods check the
+	// method themselves, the read-only ones are registered with handleGet.
+	mux := http.NewServeMux()
+	handleGet(mux, "/{$}", server.handleIndex)
+	mux.HandleFunc("/", handleNotFound)
+	handleGet(mux, "/api/media", server.handleMediaAPI)
+	mux.HandleFunc("/api/media/", server.handleMediaFileAPI)
+	handleGet(mux, "/api/schedule", server.handleScheduleAPI)
+	mux.HandleFunc("/api/sync", server.handleSyncAPI)
+	mux.HandleFunc("/api/resync", server.handleResyncAPI)
+	handleGet(mux, "/api/status", server.handleStatusAPI)
+	mux.HandleFunc("/api/ticker", server.handleTickerAPI)
+	mux.HandleFunc("/api/now-playing", server.handleNowPlayingAPI)
+	mux.HandleFunc("/api/priority", server.handlePriorityAPI)
+	mux.HandleFunc("/api/volume", server.handleVolumeAPI)
+	mux.HandleFunc("/api/pause", server.handlePauseAPI)
+	mux.HandleFunc("/api/stats", server.handleStatsAPI)
+	mux.HandleFunc("/api/config", server.handleConfigAPI)
+	mux.HandleFunc("/api/playlist", server.handlePlaylistAPI)
+	mux.HandleFunc("/api/playlist.smil", server.handlePlaylistSMIL)
+	mux.HandleFunc("/api/upload", server.handleUploadAPI)
+	handleGet(mux, "/healthz", server.handleHealthz)
+	handleGet(mux, "/idle-image", server.handleIdleImage)
+	handleGet(mux, "/splash", server.handleSplash)
+	if appconfig.HLSPlayer != "" {
+		handleGet(mux, "/hls.min.js", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/javascript")
+			http.ServeFile(w, r, appconfig.HLSPlayer)
+		})
+	}
+	handleGet(mux, "/ws", server.handleWebSocket)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/media/", mediaRoute(appconfig))
 
+	buildInfoGauge.WithLabelValues(Version).Set(1)
+	registerDiskMetrics(appconfig.MediaDir)
 	log.Printf("Digital Signage %s starting on port %s", Version, appconfig.Port)
-	log.Printf("Media directory: %s", appconfig.MediaDir)
-	if appconfig.S3Bucket != "" {
-		log.Printf("S3 sync: %s (every %v)", appconfig.S3Bucket, appconfig.SyncInterval)
+	log.Printf("Media directory: %s", strings.Join(appconfig.MediaDirs, ", "))
+	if server.source != nil {
+		log.Printf("Sync: %s/%s (every %v)", server.source, strings.Join(appconfig.S3Prefixes, ", "), appconfig.SyncInterval)
+	}
+	if !schedule.IsEmpty() {
+		log.Printf("Active hours: %s (%s)", appconfig.ActiveHours, time.Local)
 	}
 
-	if err := http.ListenAndServe(":"+appconfig.Port, nil); err != nil {
+	handler := withRateLimit(appconfig.APIRateLimit, server.requireToken(withGzip(mux)))
+	httpServer := &http.Server{
+		Addr:    ":" + appconfig.Port,
+		Handler: securityHeaders(appconfig, withCORS(appconfig.AllowOrigins, handler)),
+	}
+	// Shutdown doesn't wait for hijacked connections, close them explicitly
+	httpServer.RegisterOnShutdown(server.events.close)
+	useTLS := false
+	switch {
+	case appconfig.TLSCert != "" || appconfig.TLSKey != "":
+		if appconfig.TLSCert == "" || appconfig.TLSKey == "" {
+			log.Fatalf("Invalid TLS configuration: TLS_CERT and TLS_KEY must be set together")
+		}
+		useTLS = true
+		log.Printf("Serving HTTPS with certificate %s", appconfig.TLSCert)
+	case appconfig.TLSSelfSigned:
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			log.Fatalf("Failed to generate self-signed certificate: %v", err)
+		}
+		httpServer.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		useTLS = true
+		log.Println("Serving HTTPS with a self-signed certificate")
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if useTLS {
+			// Certificate files are ignored when TLSConfig already has one
+			serverErr <- httpServer.ListenAndServeTLS(appconfig.TLSCert, appconfig.TLSKey)
+		} else {
+			serverErr <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatalf("Server failed to start: %v", err)
+	case <-ctx.Done():
 	}
+
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	server.savePlayStats()
+
+	// Give an interrupted download the chance to clean up after itself
+	select {
+	case <-syncDone:
+	case <-shutdownCtx.Done():
+		log.Println("Timed out waiting for S3 sync to stop")
+	}
+	log.Println("Shutdown complete")
 }
 
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	tmpl := `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Digital Signage</title>
-    <style>
-        * {
-            margin: 0;
-            padding: 0;
-            box-sizing: border-box;
-        }
-        
-        body {
-            background: #000;
-            font-family: Arial, sans-serif;
-            overflow: hidden;
-            cursor: none;
-        }
-        
-        #video-container {
-		    width: 100vw;
-		    height: 100vh;
-		    display: flex;
-		    align-items: center;
-		    justify-content: center;
-		    overflow: hidden;
-        }
-
-        video {
-            width: auto;
-            height: auto;
-            max-height: 100%;
-            max-width: 100%;
-            object-fit: contain;
-        }
-
-        #loading {
-            position: absolute;
-            top: 50%;
-            left: 50%;
-            transform: translate(-50%, -50%);
-            color: white;
-            font-size: 24px;
-            text-align: center;
-        }
-        
-        #status {
-            position: absolute;
-            bottom: 20px;
-            right: 20px;
-            color: rgba(255, 255, 255, 0.7);
-            font-size: 12px;
-            background: rgba(0, 0, 0, 0.5);
-            padding: 5px 10px;
-            border-radius: 3px;
-        }
-        
-        .hidden {
-            display: none;
-        }
-    </style>
-</head>
-<body>
-    <div id="loading">Loading media...</div>
-    <div id="video-container" class="hidden">
-        <video id="video" muted autoplay></video>
-    </div>
-    <div id="status">Initializing...</div>
-
-    <script>
-        class DigitalSignage {
-            constructor() {
-                this.mediaList = [];
-                this.currentIndex = 0;
-                this.video = document.getElementById('video');
-                this.loading = document.getElementById('loading');
-                this.container = document.getElementById('video-container');
-                this.status = document.getElementById('status');
-                
-                this.init();
-            }
-            
-            async init() {
-                try {
-                    await this.loadMediaList();
-                    this.setupVideo();
-                    this.hideLoading();
-                    this.startPlayback();
-                    this.startMediaRefresh();
-                } catch (error) {
-                    console.error('Initialization failed:', error);
-                    this.showError('Failed to load media');
-                }
-            }
-            
-            async loadMediaList() {
-                const response = await fetch('/api/media');
-                const data = await response.json();
-                this.mediaList = data.media || [];
-                this.updateStatus(` + "`" + `${this.mediaList.length} media files loaded` + "`" + `);
-            }
-            
-            setupVideo() {
-                this.video.addEventListener('ended', () => {
-                    this.playNext();
-                });
-                
-                this.video.addEventListener('error', (e) => {
-                    console.error('Video error:', e);
-                    this.playNext();
-                });
-                
-                this.video.addEventListener('loadstart', () => {
-                    this.updateStatus('Loading video...');
-                });
-                
-                this.video.addEventListener('canplay', () => {
-                    this.updateStatus(` + "`" + `Playing: ${this.getCurrentMedia().name}` + "`" + `);
-                });
-            }
-            
-            hideLoading() {
-                this.loading.classList.add('hidden');
-                this.container.classList.remove('hidden');
-            }
-            
-            showError(message) {
-                this.loading.textContent = message;
-                this.updateStatus(message);
-            }
-            
-            getCurrentMedia() {
-                return this.mediaList[this.currentIndex] || null;
-            }
-            
-            async startPlayback() {
-                if (this.mediaList.length === 0) {
-                    this.showError('No media files found');
-                    return;
-                }
-                
-                this.playCurrentMedia();
-            }
-            
-            async playCurrentMedia() {
-                const media = this.getCurrentMedia();
-                if (!media) return;
-                
-                this.video.src = media.url;
-                try {
-                    await this.video.play();
-                } catch (error) {
-                    console.error('Play failed:', error);
-                    setTimeout(() => this.playNext(), 1000);
-                }
-            }
-            
-            playNext() {
-                if (this.mediaList.length === 0) return;
-                
-                this.currentIndex = (this.currentIndex + 1) % this.mediaList.length;
-                this.playCurrentMedia();
-            }
-            
-            updateStatus(message) {
-                this.status.textContent = message;
-            }
-            
-            startMediaRefresh() {
-                // Refresh media list every 5 minutes
-                setInterval(async () => {
-                    try {
-                        const oldCount = this.mediaList.length;
-                        await this.loadMediaList();
-                        
-                        if (this.mediaList.length !== oldCount) {
-                            console.log('Media list updated');
-                            // Reset to beginning if current index is out of bounds
-                            if (this.currentIndex >= this.mediaList.length) {
-                                this.currentIndex = 0;
-                                this.playCurrentMedia();
-                            }
-                        }
-                    } catch (error) {
-                        console.error('Failed to refresh media list:', error);
-                    }
-                }, 5 * 60 * 1000);
-            }
-        }
-        
-        // Start the application
-        document.addEventListener('DOMContentLoaded', () => {
-            new DigitalSignage();
-        });
-        
-        // Prevent context menu and other interactions
-        document.addEventListener('contextmenu', e => e.preventDefault());
-        document.addEventListener('keydown', e => {
-            if (e.key === 'F5' || (e.ctrlKey && e.key === 'r')) {
-                e.preventDefault();
-            }
-        });
-    </script>
-</body>
-</html>`
+	var page bytes.Buffer
+	err := s.page.Execute(&page, indexPage{
+		Nonce:  cspNonce(r),
+		Config: s.playerConfig(),
+		HLS:    s.config.HLSPlayer != "",
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/html")
-	fmt.Fprint(w, tmpl)
+	page.WriteTo(w)
+}
+
+// PlayerConfig holds the settings embedded into the display page
+type PlayerConfig struct {
+	Interactive   bool   `json:"interactive"`
+	Controls      bool   `json:"controls"`
+	ImageDuration int    `json:"image_duration"`
+	Version       string `json:"version"`
+	SingleLoop    bool   `json:"single_loop"`
+	MediaRefresh  int    `json:"media_refresh"`
+	Muted         bool   `json:"muted"`
+	MaxFailures   int    `json:"max_failures"`
+	FailurePause  int    `json:"failure_pause"`
+	Transition    string `json:"transition"`
+	TransitionMS  int    `json:"transition_ms"`
+	Layout        string `json:"layout"`
+	// ScheduleOn and ScheduleOff are the daily HH:MM times from
+	// SCHEDULE_ON and SCHEDULE_OFF, if set
+	ScheduleOn  string `json:"schedule_on,omitempty"`
+	ScheduleOff string `json:"schedule_off,omitempty"`
+	// Splash is played once before the playlist, if set
+	Splash *splashMedia `json:"splash,omitempty"`
+}
+
+func (s *Server) playerConfig() PlayerConfig {
+	splash, _ := s.splash()
+	return PlayerConfig{
+		Interactive:   s.config.Interactive,
+		Controls:      s.config.Interactive && s.config.InteractiveControls,
+		ImageDuration: int(s.config.ImageDuration.Seconds()),
+		Version:       Version,
+		SingleLoop:    s.config.SingleLoop,
+		MediaRefresh:  int(s.config.UIRefresh.Seconds()),
+		Muted:         s.config.Muted,
+		MaxFailures:   s.config.MaxFailures,
+		FailurePause:  int(s.config.FailurePause.Seconds()),
+		Transition:    s.config.Transition,
+		TransitionMS:  int(s.config.TransitionTime.Milliseconds()),
+		Layout:        s.config.Layout,
+		ScheduleOn:    s.config.ScheduleOn,
+		ScheduleOff:   s.config.ScheduleOff,
+		Splash:        splash,
+	}
+}
+
+// handleConfigAPI returns the settings the display page is rendered with
+func (s *Server) handleConfigAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.playerConfig())
 }
 
 func (s *Server) handleMediaAPI(w http.ResponseWriter, r *http.Request) {
-	s.scanMedia()
+	// Syncs, uploads and deletes rescan themselves, walking the directories
+	// on every poll is only done on request
+	if r.URL.Query().Get("refresh") == "true" {
+		s.scanMedia()
+	}
 
+	files := s.playlistFiles(r)
+	playlist := weightMedia(files, s.config.ImageWeight, s.config.VideoWeight)
+	s.mediaMu.RLock()
+	listOrder := s.listOrder
+	s.mediaMu.RUnlock()
 	response := map[string]interface{}{
-		"media": s.mediaList,
-		"count": len(s.mediaList),
+		"media":   playlist,
+		"count":   len(playlist),
+		"order":   describeOrder(files, playlist, listOrder),
+		"version": Version,
+	}
+	if len(layoutRegions[s.config.Layout]) > 0 {
+		response["regions"] = groupByRegion(s.config.Layout, playlist)
+	}
+	if next := nextMediaChange(s.currentMedia(), time.Now()); !next.IsZero() {
+		response["next_change"] = next.Format(time.RFC3339)
+	}
+	if s.idleImageInfo() != nil {
+		response["idle_image"] = "/idle-image"
 	}
 
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(response); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if notModified(w, r, body.Bytes()) {
+		return
+	}
+	body.WriteTo(w)
+}
+
+// notModified sets an ETag hashed from the response body and answers 304 if
+// the client already has it. The ETag is weak, the body may be gzipped.
+func notModified(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	h := fnv.New64a()
+	h.Write(body)
+	etag := fmt.Sprintf(`W/"%016x"`, h.Sum64())
+	w.Header().Set("ETag", etag)
+
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// handleMediaFileAPI returns the details of a single file, named by its path
+// relative to its media directory, e.g. /api/media/promo/spring.mp4, or
+// deletes it from MediaDir on DELETE
+func (s *Server) handleMediaFileAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	// Deleting is destructive, so like resync it needs API credentials
+	if r.Method == http.MethodDelete && !s.authConfigured() {
+		writeJSONError(w, http.StatusForbidden, "no API credentials are configured")
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/media/")
+	if name == "" || path.IsAbs(name) || strings.HasPrefix(name, "\\") ||
+		slices.Contains(strings.Split(strings.ReplaceAll(name, "\\", "/"), "/"), "..") {
+		writeJSONError(w, http.StatusBadRequest, "invalid file name")
+		return
+	}
+
+	// Looked up in the cached list like /api/media, deleteMedia rescans
+	if r.URL.Query().Get("refresh") == "true" {
+		s.scanMedia()
+	}
+	media, ok := s.findMedia(name)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no such media file")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		s.deleteMedia(w, media)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(media)
+}
+
+// deleteMedia removes a file in MediaDir and rescans
+func (s *Server) deleteMedia(w http.ResponseWriter, media MediaFile) {
+	if media.root != 0 {
+		writeJSONError(w, http.StatusForbidden, "only files in MEDIA_DIR can be deleted")
+		return
+	}
+	if err := os.Remove(media.Path); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pruneEmptyDirs(filepath.Dir(media.Path), s.config.MediaDir)
+
+	relPath, _ := filepath.Rel(s.config.MediaDir, media.Path)
+	key := filepath.ToSlash(relPath)
+	log.Printf("Deleted %s through the API", media.Path)
+	s.scanMedia()
+
+	response := map[string]interface{}{
+		"deleted": key,
+	}
+	if s.source != nil {
+		response["warning"] = "the next sync downloads the file again unless it is also removed from " + s.source.String()
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-func (s *Server) scanMedia() {
-	var mediaFiles []MediaFile
-	supportedExts := map[string]bool{
-		".mp4": true, ".avi": true, ".mov": true, ".mkv": true,
-		".webm": true, ".m4v": true, ".3gp": true,
+// idleImageInfo returns the idle image's file info, or nil if there is none
+func (s *Server) idleImageInfo() os.FileInfo {
+	if s.config.IdleImage == "" {
+		return nil
+	}
+	info, err := os.Stat(s.config.IdleImage)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	return info
+}
+
+// splashMedia is the file the display plays once before the playlist
+type splashMedia struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Type string `json:"type"`
+}
+
+// splash returns the SPLASH_MEDIA file with its info, nil if it is unset,
+// missing or not a media type
+func (s *Server) splash() (*splashMedia, os.FileInfo) {
+	if s.config.SplashMedia == "" {
+		return nil, nil
 	}
+	info, err := os.Stat(s.config.SplashMedia)
+	if err != nil || info.IsDir() {
+		return nil, nil
+	}
+	mediaType, ok := s.config.MediaExtensions[strings.ToLower(filepath.Ext(info.Name()))]
+	if !ok {
+		return nil, nil
+	}
+	return &splashMedia{Name: info.Name(), URL: "/splash", Type: mediaType}, info
+}
 
-	err := filepath.Walk(s.config.MediaDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// handleSplash serves the SPLASH_MEDIA file
+func (s *Server) handleSplash(w http.ResponseWriter, r *http.Request) {
+	if splash, _ := s.splash(); splash == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, s.config.SplashMedia)
+}
+
+// handleIdleImage serves the image the display shows while it has no media
+func (s *Server) handleIdleImage(w http.ResponseWriter, r *http.Request) {
+	if s.idleImageInfo() == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, s.config.IdleImage)
+}
+
+// effectivePlaylist returns the media the requesting display should play
+// right now, with duplicates collapsed and weighted files repeated.
+//
+// Weighting happens here rather than in scanMedia, the media list itself
+// must name each file once for the sync.
+func (s *Server) effectivePlaylist(r *http.Request) []MediaFile {
+	return weightMedia(s.playlistFiles(r), s.config.ImageWeight, s.config.VideoWeight)
+}
+
+// playlistFiles returns the files of the requesting display's playlist, each
+// once, before weighting. The configured RESOLUTION takes precedence over the
+// height the player reports.
+func (s *Server) playlistFiles(r *http.Request) []MediaFile {
+	target := s.config.Resolution
+	if target == 0 {
+		if height, err := parseResolution(r.URL.Query().Get("height")); err == nil {
+			target = height
 		}
+	}
+	playlist := selectRenditions(activeMedia(s.currentMedia(), time.Now()), target)
+	if s.config.Dedup {
+		playlist = dedupMedia(playlist)
+	}
+	if region := r.URL.Query().Get("region"); region != "" {
+		playlist = regionMedia(playlist, region)
+	}
+	return playlist
+}
 
-		if !info.IsDir() {
-			ext := strings.ToLower(filepath.Ext(path))
-			if supportedExts[ext] {
-				relPath, _ := filepath.Rel(s.config.MediaDir, path)
-				mediaFile := MediaFile{
-					Name: info.Name(),
-					Path: path,
-					URL:  "/media/" + filepath.ToSlash(relPath),
-				}
-				mediaFiles = append(mediaFiles, mediaFile)
-			}
+func (s *Server) handleScheduleAPI(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	response := map[string]interface{}{
+		"active":     s.schedule.IsActive(now),
+		"configured": !s.schedule.IsEmpty(),
+		"timezone":   time.Local.String(),
+	}
+	if next := s.schedule.NextChange(now); !next.IsZero() {
+		response["next_change"] = next.Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) handleSyncAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.runRequestedSync(w, r, false, s.config.SyncDryRun || r.URL.Query().Get("dry") == "true")
+}
+
+func (s *Server) handleResyncAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	// Purging is destructive, so unlike other endpoints it is never open.
+	// The credentials themselves are checked by requireToken.
+	if !s.authConfigured() {
+		writeJSONError(w, http.StatusForbidden, "no API credentials are configured")
+		return
+	}
+	s.runRequestedSync(w, r, r.URL.Query().Get("purge") == "true", s.config.SyncDryRun || r.URL.Query().Get("dry") == "true")
+}
+
+// runRequestedSync runs a sync on behalf of an API request, optionally
+// purging local media first or only planning the changes, and responds with
+// the result. It refuses to start while another sync is running.
+func (s *Server) runRequestedSync(w http.ResponseWriter, r *http.Request, purge, dryRun bool) {
+	if s.source == nil {
+		writeJSONError(w, http.StatusBadRequest, "sync is not configured")
+		return
+	}
+	if purge && dryRun {
+		writeJSONError(w, http.StatusBadRequest, "a purge can't be a dry run")
+		return
+	}
+	if !s.syncMu.TryLock() {
+		writeJSONError(w, http.StatusConflict, "a sync is already in progress")
+		return
+	}
+	defer s.syncMu.Unlock()
+
+	response := map[string]interface{}{
+		"purge":   purge,
+		"dry_run": dryRun,
+	}
+
+	if purge {
+		purged, err := s.purgeMedia()
+		response["purged"] = purged
+		if err != nil {
+			log.Printf("Purge failed: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
 		}
-		return nil
-	})
+	}
 
+	result, err := s.reconcile(r.Context(), dryRun)
 	if err != nil {
-		log.Printf("Error scanning media directory: %v", err)
+		slog.Error("Sync failed", "event", "sync_error", "error", err)
+		s.recordSyncError(err)
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
 	}
+	response["downloaded"] = len(result.Added) + len(result.Updated)
+	response["deleted"] = len(result.Deleted)
+	response["changes"] = result
 
-	// Sort by name for consistent playback order
-	sort.Slice(mediaFiles, func(i, j int) bool {
-		return mediaFiles[i].Name < mediaFiles[j].Name
-	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	s.mediaList = mediaFiles
-	log.Printf("Found %d media files", len(mediaFiles))
+func (s *Server) handleStatusAPI(w http.ResponseWriter, r *http.Request) {
+	s.stateMu.Lock()
+	state := s.syncState
+	oversized := s.oversized
+	s.stateMu.Unlock()
+
+	response := map[string]interface{}{
+		"version":              Version,
+		"media_count":          len(s.currentMedia()),
+		"sync_enabled":         s.source != nil,
+		"last_sync":            state.LastSync,
+		"consecutive_failures": state.ConsecutiveFailures,
+	}
+	if !state.LastAttempt.IsZero() {
+		response["last_attempt"] = state.LastAttempt.Format(time.RFC3339)
+	}
+	if state.LastError != "" {
+		response["last_error"] = state.LastError
+	}
+	if quarantined := s.quarantinedKeys(); len(quarantined) > 0 {
+		response["quarantined"] = quarantined
+	}
+	if len(oversized) > 0 {
+		response["oversized"] = oversized
+	}
+	if usage, err := diskSpace(s.config.MediaDir); err == nil {
+		response["disk_total_bytes"] = usage.Total
+		response["disk_used_bytes"] = usage.Used
+		response["disk_free_bytes"] = usage.Free
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
-func (s *Server) syncLoop() {
-	log.Println("Starting S3 sync loop")
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"status":      "ok",
+		"version":     Version,
+		"media_count": len(s.currentMedia()),
+	}
+	status := http.StatusOK
+
+	for _, dir := range s.config.MediaDirs {
+		if err := checkDirReadable(dir); err != nil {
+			response["status"] = "error"
+			response["error"] = err.Error()
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
 
-	// Initial sync
-	s.syncFromS3()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
 
-	// Periodic sync
-	ticker := time.NewTicker(s.config.SyncInterval)
-	defer ticker.Stop()
+func checkDirReadable(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	for range ticker.C {
-		s.syncFromS3()
+	if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+		return err
 	}
+	return nil
+}
+
+// handleGet routes GET and HEAD requests for pattern to handler and answers
+// any other method with 405
+func handleGet(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	mux.HandleFunc("GET "+pattern, handler)
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
 }
 
-func (s *Server) syncFromS3() {
-	if s.s3Client == nil {
+// handleNotFound answers unknown paths, with JSON below /api/ and the error
+// page for anything a browser may have navigated to
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeJSONError(w, http.StatusNotFound, "not found")
 		return
 	}
 
-	log.Println("Starting S3 sync...")
-	ctx := context.Background()
-
-	// List objects in S3 bucket
-	resp, err := s.s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-		Bucket: aws.String(s.config.S3Bucket),
+	var page bytes.Buffer
+	err := errorPage.Execute(&page, map[string]interface{}{
+		"Nonce":  cspNonce(r),
+		"Reload": errorPageReload,
+		"Status": http.StatusText(http.StatusNotFound),
 	})
 	if err != nil {
-		log.Printf("Failed to list S3 objects: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusNotFound)
+	page.WriteTo(w)
+}
 
-	localFilesToRemove := make([]string, len(s.mediaList))
-	for i := range len(s.mediaList) {
-		localFilesToRemove[i] = s.mediaList[i].Path
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func (s *Server) scanMedia() {
+	var mediaFiles []MediaFile
+	// The idle image and splash may live in a media directory, they are
+	// never part of the playlist
+	idleImage := s.idleImageInfo()
+	_, splash := s.splash()
+
+	for root, dir := range s.config.MediaDirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if relPath, _ := filepath.Rel(dir, path); relPath != "." && s.config.MediaFilter.skipsDir(filepath.ToSlash(relPath)) {
+					return filepath.SkipDir
+				}
+			} else {
+				ext := strings.ToLower(filepath.Ext(path))
+				if mediaType, ok := s.config.MediaExtensions[ext]; ok {
+					if (idleImage != nil && os.SameFile(info, idleImage)) || (splash != nil && os.SameFile(info, splash)) {
+						return nil
+					}
+					relPath, _ := filepath.Rel(dir, path)
+					if !s.config.MediaFilter.allows(filepath.ToSlash(relPath)) {
+						return nil
+					}
+					// An empty file only makes the player flicker past it
+					if info.Size() == 0 {
+						log.Printf("Skipping empty media file %s", path)
+						return nil
+					}
+
+					_, resolution := parseRendition(info.Name())
+					mediaFile := MediaFile{
+						Name:       info.Name(),
+						Path:       path,
+						URL:        s.mediaURL(root, relPath, info.ModTime()),
+						Type:       mediaType,
+						Resolution: resolution,
+						root:       root,
+						Size:       info.Size(),
+						ModTime:    info.ModTime(),
+						Source:     s.prefixOf(root, relPath),
+						Region:     regionOf(s.config.Layout, filepath.ToSlash(relPath)),
+					}
+					if s.config.Dedup {
+						mediaFile.hash = s.hashes.get(path, info, contentHash)
+					}
+					if s.config.ProbeDuration && mediaType == "video" {
+						if duration := s.durations.get(path, info, probeDuration); duration > 0 {
+							mediaFile.Duration = &duration
+						}
+					}
+					mediaFiles = append(mediaFiles, mediaFile)
+				}
+			}
+			return nil
+		})
+
+		if err != nil {
+			log.Printf("Error scanning media directory %s: %v", dir, err)
+		}
 	}
-	syncCount := 0
-	for _, obj := range resp.Contents {
-		if obj.Key == nil {
-			continue
+
+	mediaFiles = dropVariantPlaylists(mediaFiles)
+
+	s.stateMu.Lock()
+	sortOrder, order := s.sortOrder, s.order
+	s.stateMu.Unlock()
+	// Sort for consistent playback order
+	sortMedia(mediaFiles, sortOrder)
+	listOrder := sortOrder
+	if len(s.config.S3Prefixes) > 1 {
+		mediaFiles = interleaveSources(mediaFiles)
+	}
+	if len(order) > 0 {
+		applyPlaylistOrder(mediaFiles, order, s.config.MediaDirs)
+		listOrder = "manual"
+	}
+
+	// A playlist.json in the media directory takes precedence over the order
+	playlist, err := loadPlaylistFile(s.config.MediaDir)
+	if err == nil && playlist != nil {
+		if err = playlist.apply(mediaFiles, s.config.MediaDir); err == nil {
+			listOrder = playlistFileName
 		}
+	}
+	if err != nil {
+		log.Printf("Ignoring invalid %s: %v", playlistFileName, err)
+	}
+
+	s.mediaMu.Lock()
+	changed := mediaListChanged(s.mediaList, mediaFiles)
+	s.mediaList = mediaFiles
+	s.listOrder = listOrder
+	s.mediaMu.Unlock()
+	if changed {
+		s.events.publish(eventMediaChanged)
+	}
+	mediaFilesGauge.Set(float64(len(mediaFiles)))
+	log.Printf("Found %d media files", len(mediaFiles))
+}
 
-		fileName := *obj.Key
-		localPath := filepath.Join(s.config.MediaDir, fileName)
+const defaultMediaExtensions = "mp4,avi,mov,mkv,webm,m4v,3gp,jpg,jpeg,png,gif,webp"
 
-		// Check if file exists
-		if _, err := os.Stat(localPath); err == nil {
-			// Delete from known localfiles
-			index := slices.Index(localFilesToRemove, localPath)
-			if index != -1 {
-				localFilesToRemove = slices.Delete(localFilesToRemove, index, index+1)
-			}
+// imageExtensions are shown as images, every other scanned extension is
+// played as video
+var imageExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".avif": true, ".bmp": true, ".svg": true,
+}
+
+// parseMediaExtensions parses a comma-separated extension list such as
+// "mp4,.MOV,jpg", ignoring case and leading dots
+func parseMediaExtensions(spec string) (map[string]string, error) {
+	extensions := make(map[string]string)
+	for _, ext := range strings.Split(spec, ",") {
+		ext = strings.ToLower(strings.TrimLeft(strings.TrimSpace(ext), "."))
+		if ext == "" {
 			continue
 		}
-		// // Check if file exists and has same size
-		// if info, err := os.Stat(localPath); err == nil {
-		// 	if info.Size() == obj.Size {
-		// 		continue // File already exists with same size
-		// 	}
-		// }
+		if strings.ContainsAny(ext, "./\\") {
+			return nil, fmt.Errorf("invalid extension %q", ext)
+		}
+		ext = "." + ext
+		if imageExtensions[ext] {
+			extensions[ext] = "image"
+		} else if ext == ".m3u8" {
+			extensions[ext] = "hls"
+		} else {
+			extensions[ext] = "video"
+		}
+	}
+	if len(extensions) == 0 {
+		return nil, fmt.Errorf("no extensions given")
+	}
+	return extensions, nil
+}
 
-		// Download file
-		if err := s.downloadFromS3(ctx, fileName, localPath); err != nil {
-			log.Printf("Failed to download %s: %v", fileName, err)
-			continue
+// prefixOf returns the S3_PREFIX folder, without the trailing slash, a file
+// in MediaDir belongs to when several prefixes are synced
+func (s *Server) prefixOf(root int, relPath string) string {
+	if root != 0 || len(s.config.S3Prefixes) <= 1 {
+		return ""
+	}
+	key := filepath.ToSlash(relPath)
+	for _, prefix := range s.config.S3Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return strings.TrimSuffix(prefix, "/")
+		}
+	}
+	return ""
+}
+
+// currentMedia returns the result of the last scan, callers must not modify it
+func (s *Server) currentMedia() []MediaFile {
+	s.mediaMu.RLock()
+	defer s.mediaMu.RUnlock()
+	return s.mediaList
+}
+
+// mediaListChanged reports whether a scan found different files, or the same
+// files in a different order, with new content or another schedule
+func mediaListChanged(old, current []MediaFile) bool {
+	return !slices.EqualFunc(old, current, func(a, b MediaFile) bool {
+		return a.URL == b.URL && a.ModTime.Equal(b.ModTime) && a.Size == b.Size && a.excluded == b.excluded && a.MaxDuration == b.MaxDuration && a.weight == b.weight &&
+			(a.Volume == nil) == (b.Volume == nil) && (a.Volume == nil || *a.Volume == *b.Volume) &&
+			(a.schedule == nil) == (b.schedule == nil) &&
+			(a.schedule == nil || slices.Equal(a.schedule.windows, b.schedule.windows))
+	})
+}
+
+// mediaURL builds the URL a file is served under. With several media
+// directories the path is namespaced by the directory index to avoid
+// collisions between files with the same relative path. The modification
+// time is added as a version, so a file replaced under the same name isn't
+// played from the browser cache; the file server ignores it. Each path
+// segment is escaped, so names with spaces, "#" or "?" still resolve.
+func (s *Server) mediaURL(root int, relPath string, modTime time.Time) string {
+	segments := strings.Split(filepath.ToSlash(relPath), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	escaped := strings.Join(segments, "/")
+	version := "?v=" + strconv.FormatInt(modTime.Unix(), 10)
+	if len(s.config.MediaDirs) == 1 {
+		return "/media/" + escaped + version
+	}
+	return "/media/" + strconv.Itoa(root) + "/" + escaped + version
+}
+
+// mediaRoute serves /media/ with the filter, headers and access log the
+// configuration asks for
+func mediaRoute(cfg AppConfig) http.Handler {
+	var handler http.Handler = http.StripPrefix("/media/", withMediaFilter(cfg.MediaFilter, len(cfg.MediaDirs) > 1,
+		withMediaHeaders(cfg.CacheControl, newMediaHandler(cfg.MediaDirs))))
+	if cfg.AccessLog {
+		handler = withAccessLog(handler)
+	}
+	return handler
+}
+
+// newMediaHandler serves files from the media directories, resolving the
+// namespaced URLs produced by mediaURL when there is more than one.
+func newMediaHandler(dirs []string) http.Handler {
+	if len(dirs) == 1 {
+		return http.FileServer(http.Dir(dirs[0]))
+	}
+
+	handlers := make([]http.Handler, len(dirs))
+	for i, dir := range dirs {
+		handlers[i] = http.StripPrefix(strconv.Itoa(i), http.FileServer(http.Dir(dir)))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index, _, _ := strings.Cut(r.URL.Path, "/")
+		root, err := strconv.Atoi(index)
+		if err != nil || root < 0 || root >= len(handlers) || strconv.Itoa(root) != index {
+			http.NotFound(w, r)
+			return
 		}
+		handlers[root].ServeHTTP(w, r)
+	})
+}
 
-		syncCount++
-		log.Printf("Downloaded: %s", fileName)
+// mediaContentTypes pins the Content-Type of media files, the system mime
+// database is often missing container formats like .mkv on small devices
+var mediaContentTypes = map[string]string{
+	".mp4": "video/mp4", ".m4v": "video/mp4", ".mov": "video/quicktime",
+	".mkv": "video/x-matroska", ".webm": "video/webm", ".avi": "video/x-msvideo",
+	".3gp": "video/3gpp", ".jpg": "image/jpeg", ".jpeg": "image/jpeg",
+	".png": "image/png", ".gif": "image/gif", ".webp": "image/webp",
+	".ogv": "video/ogg", ".flv": "video/x-flv", ".avif": "image/avif",
+	".m3u8": "application/vnd.apple.mpegurl", ".ts": "video/mp2t",
+}
+
+// withMediaHeaders advertises range support and sets an explicit
+// Content-Type so clients can seek and buffer progressively. The
+// Cache-Control header lets proxies and CDNs in front keep the files.
+func withMediaHeaders(cacheControl string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ext := strings.ToLower(path.Ext(r.URL.Path))
+		contentType, ok := mediaContentTypes[ext]
+		if !ok {
+			contentType = mime.TypeByExtension(ext)
+		}
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		// A live HLS playlist changes with every segment
+		if ext == ".m3u8" {
+			w.Header().Set("Cache-Control", "no-cache")
+		} else if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func loadConfig() AppConfig {
+	extraDirs := splitDirList(getEnv("MEDIA_DIRS", ""))
+
+	defaultMediaDir := "./media"
+	if len(extraDirs) > 0 {
+		defaultMediaDir = extraDirs[0]
 	}
+	mediaDir := getEnv("MEDIA_DIR", defaultMediaDir)
+	hostname, _ := os.Hostname()
 
-	if len(localFilesToRemove) > 0 {
-		log.Printf("%d files were deleted from S3 and need to be deleted from local storage", len(localFilesToRemove))
-		for _, localF := range localFilesToRemove {
-			os.Remove(localF)
+	// The sync target always comes first, followed by any extra directories
+	mediaDirs := []string{mediaDir}
+	for _, dir := range extraDirs {
+		if !slices.ContainsFunc(mediaDirs, func(d string) bool { return filepath.Clean(d) == filepath.Clean(dir) }) {
+			mediaDirs = append(mediaDirs, dir)
 		}
 	}
 
-	if syncCount > 0 {
-		log.Printf("S3 sync completed: %d files updated", syncCount)
-		s.scanMedia() // Refresh media list
-	} else {
-		log.Println("S3 sync completed: no updates needed")
+	return AppConfig{
+		MediaDir:       mediaDir,
+		MediaDirs:      mediaDirs,
+		StorageBackend: strings.ToLower(getEnv("STORAGE_BACKEND", "s3")),
+		S3Bucket:       getEnv("S3_BUCKET", ""),
+		GCSBucket:      getEnv("GCS_BUCKET", ""),
+		S3Region:       getEnv("S3_REGION", "sa-east-1"),
+		S3Prefixes:     normalizePrefixes(getEnv("S3_PREFIX", "")),
+		S3Endpoint:     getEnv("S3_ENDPOINT", ""),
+		S3PathStyle:    getEnvBool("S3_FORCE_PATH_STYLE", false),
+		S3KMSKey:       getEnv("S3_SSE_KMS_KEY", ""),
+		RequireSync:    getEnvBool("REQUIRE_S3", false),
+		S3Concurrency:  getEnvInt("S3_CONCURRENCY", 4),
+		S3MaxRetries:   max(getEnvInt("S3_MAX_RETRIES", 3), 0),
+		SyncDryRun:     getEnvBool("SYNC_DRY_RUN", false),
+		DeleteOrphans:  getEnvBool("SYNC_DELETE_ORPHANS", false),
+		MaxDiskBytes:   int64(getEnvInt("MAX_DISK_BYTES", 0)),
+		MaxFileBytes:   int64(getEnvInt("MAX_FILE_BYTES", 0)),
+		MaxUploadBytes: int64(getEnvInt("MAX_UPLOAD_BYTES", 1<<30)),
+		SyncInterval:   time.Duration(getEnvInt("SYNC_INTERVAL_MINUTES", 15)) * time.Minute,
+		Port:           getEnv("PORT", "8080"),
+		CaseCollision:  strings.ToLower(getEnv("S3_CASE_COLLISION", "first")),
+		SyncCompare:    strings.ToLower(getEnv("S3_COMPARE", "etag")),
+		DownloadOrder:  strings.ToLower(getEnv("S3_DOWNLOAD_ORDER", "key")),
+		ActiveHours:    getEnv("ACTIVE_HOURS", ""),
+		ScheduleOn:     getEnv("SCHEDULE_ON", ""),
+		ScheduleOff:    getEnv("SCHEDULE_OFF", ""),
+		APIToken:       getEnv("API_TOKEN", ""),
+		BasicAuthUser:  getEnv("BASIC_AUTH_USER", ""),
+		BasicAuthPass:  getEnv("BASIC_AUTH_PASS", ""),
+		WebhookURL:     getEnv("WEBHOOK_URL", ""),
+		HeartbeatURL:   getEnv("HEARTBEAT_URL", ""),
+		HeartbeatEvery: time.Duration(max(getEnvInt("HEARTBEAT_SECONDS", 60), 1)) * time.Second,
+		DeviceID:       getEnv("DEVICE_ID", hostname),
+		CSP:            getEnvOptional("CSP_POLICY", defaultCSP),
+		FrameOptions:   getEnvOptional("FRAME_OPTIONS", "DENY"),
+		CacheControl:   getEnvOptional("MEDIA_CACHE_CONTROL", "public, max-age=3600"),
+		TLSCert:        getEnv("TLS_CERT", ""),
+		TLSKey:         getEnv("TLS_KEY", ""),
+		TLSSelfSigned:  getEnvBool("TLS_SELF_SIGNED", false),
+		AllowOrigins:   splitList(getEnv("ALLOW_ORIGINS", "")),
+		APIRateLimit:   max(getEnvInt("API_RATE_LIMIT", 0), 0),
+
+		Interactive:         getEnvBool("INTERACTIVE", false),
+		InteractiveControls: getEnvBool("INTERACTIVE_CONTROLS", false),
+		ImageDuration:       time.Duration(getEnvInt("IMAGE_DURATION_SECONDS", 10)) * time.Second,
+		PlaylistOrder:       strings.ToLower(getEnv("PLAYLIST_ORDER", "name")),
+		ImageWeight:         max(getEnvInt("IMAGE_WEIGHT", 1), 1),
+		SingleLoop:          getEnvBool("SINGLE_LOOP", false),
+		Dedup:               getEnvBool("DEDUP", false),
+		ProbeDuration:       getEnvBool("PROBE_DURATION", false),
+		Muted:               getEnvBool("MUTED", true),
+		AccessLog:           getEnvBool("ACCESS_LOG", true),
+		HLSPlayer:           getEnv("HLS_JS", ""),
+		IndexTemplate:       getEnv("INDEX_TEMPLATE", ""),
+		UIRefresh:           time.Duration(max(getEnvInt("UI_REFRESH_SECONDS", 300), 10)) * time.Second,
+		MaxFailures:         max(getEnvInt("MAX_PLAY_FAILURES", 5), 1),
+		FailurePause:        time.Duration(max(getEnvInt("FAILURE_PAUSE_SECONDS", 60), 1)) * time.Second,
+		Transition:          strings.ToLower(getEnv("TRANSITION", "none")),
+		Layout:              strings.ToLower(getEnv("LAYOUT", "fullscreen")),
+		TransitionTime:      time.Duration(max(getEnvInt("TRANSITION_MS", 500), 0)) * time.Millisecond,
+		VideoWeight:         max(getEnvInt("VIDEO_WEIGHT", 1), 1),
+		ValidateMedia:       getEnvBool("VALIDATE_MEDIA", true),
+		IdleImage:           getEnvOptional("IDLE_IMAGE", filepath.Join(mediaDir, "idle.png")),
+		SplashMedia:         getEnv("SPLASH_MEDIA", ""),
+		TickerText:          strings.TrimSpace(getEnv("TICKER_TEXT", "")),
 	}
 }
 
-func (s *Server) downloadFromS3(ctx context.Context, key, localPath string) error {
-	// Create directory if needed
-	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
-		return err
+// splitDirList splits a list of directories on commas and the system's path
+// list separator, a colon on Unix and a semicolon on Windows
+func splitDirList(value string) []string {
+	var dirs []string
+	for _, item := range splitList(value) {
+		for _, dir := range filepath.SplitList(item) {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
 	}
+	return dirs
+}
 
-	// Download from S3
-	resp, err := s.s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(s.config.S3Bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return err
+// splitList splits a comma-separated value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
-	defer resp.Body.Close()
+	return items
+}
 
-	// Create local file
-	file, err := os.Create(localPath)
-	if err != nil {
-		return err
+// normalizePrefixes splits S3_PREFIX into its folders, dropping duplicates
+func normalizePrefixes(value string) []string {
+	var prefixes []string
+	for _, item := range splitList(value) {
+		if prefix := normalizePrefix(item); prefix != "" && !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
 	}
-	defer file.Close()
+	return prefixes
+}
 
-	// Copy data
-	_, err = io.Copy(file, resp.Body)
-	return err
+// normalizePrefix treats the S3 prefix as a folder, so "store-01" doesn't
+// also match "store-010/"
+func normalizePrefix(prefix string) string {
+	prefix = strings.TrimPrefix(strings.TrimSpace(prefix), "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
 }
 
 func getEnv(key, defaultValue string) string {
@@ -511,6 +1478,25 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvOptional is like getEnv but lets "off" disable a setting that is
+// otherwise on by default
+func getEnvOptional(key, defaultValue string) string {
+	value := getEnv(key, defaultValue)
+	if strings.EqualFold(value, "off") {
+		return ""
+	}
+	return value
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
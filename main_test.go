@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMediaURLRoundTrip(t *testing.T) {
+	names := []string{
+		"Summer Sale 2024.mp4",
+		"Süd/café ☕.mp4",
+		"a+b.mp4",
+		"50% #1?.mp4",
+		"promo/spring & summer;v2.jpg",
+	}
+
+	for _, dirCount := range []int{1, 2} {
+		var dirs []string
+		for range dirCount {
+			dirs = append(dirs, t.TempDir())
+		}
+		root := dirCount - 1
+		for _, name := range names {
+			path := filepath.Join(dirs[root], filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		filter, err := parseNameFilter("", "", ".*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := AppConfig{MediaDirs: dirs, MediaFilter: filter}
+		server := httptest.NewServer(mediaRoute(cfg))
+		s := &Server{config: cfg}
+
+		for _, name := range names {
+			url := s.mediaURL(root, filepath.FromSlash(name), time.Unix(1700000000, 0))
+			if path, _, _ := strings.Cut(url, "?v="); strings.ContainsAny(path, " #?") {
+				t.Errorf("%q: URL %q is not escaped", name, url)
+			}
+
+			resp, err := http.Get(server.URL + url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != name {
+				t.Errorf("%d dirs, %q: GET %s = %d %q", dirCount, name, url, resp.StatusCode, body)
+			}
+		}
+		server.Close()
+	}
+}
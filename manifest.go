@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const manifestFileName = ".sync-manifest.json"
+
+// objectRecord describes the remote object a local file was downloaded from
+type objectRecord struct {
+	ETag         string    `json:"etag"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// syncManifest remembers which version of each remote object is on disk,
+// keyed by the file name relative to the sync target. It is stored as a
+// hidden file in the sync target so it survives restarts.
+type syncManifest struct {
+	path    string
+	Objects map[string]objectRecord `json:"objects"`
+}
+
+func loadManifest(dir string) (*syncManifest, error) {
+	manifest := &syncManifest{
+		path:    filepath.Join(dir, manifestFileName),
+		Objects: make(map[string]objectRecord),
+	}
+
+	data, err := os.ReadFile(manifest.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return manifest, err
+	}
+	if manifest.Objects == nil {
+		manifest.Objects = make(map[string]objectRecord)
+	}
+	return manifest, nil
+}
+
+// save writes the manifest to a temporary file and renames it into place so
+// a crash never leaves a truncated manifest behind
+func (m *syncManifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+func (m *syncManifest) record(name string, obj RemoteObject) {
+	m.Objects[name] = objectRecord{
+		ETag:         obj.ETag,
+		Size:         obj.Size,
+		LastModified: obj.LastModified,
+	}
+}
+
+// changed reports whether the remote object differs from the local copy. The
+// ETag of an S3 multipart upload is not an MD5 of the content and may differ
+// for identical data, so those, and objects without an ETag, are compared by
+// size and LastModified instead. With byModTime only an object modified after
+// the local copy was downloaded counts as changed.
+func (m *syncManifest) changed(name string, obj RemoteObject, localSize int64, byModTime bool) bool {
+	if localSize != obj.Size {
+		return true
+	}
+
+	record, ok := m.Objects[name]
+	if !ok {
+		// File predates the manifest, trust the matching size
+		return false
+	}
+
+	if byModTime {
+		return obj.LastModified.After(record.LastModified)
+	}
+	if obj.ETag != "" && !strings.Contains(obj.ETag, "-") {
+		return obj.ETag != record.ETag
+	}
+	return record.Size != obj.Size || !record.LastModified.Equal(obj.LastModified)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	s3DownloadsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "signage_s3_downloads_total",
+		Help: "Number of files downloaded from S3.",
+	})
+	s3DeletesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "signage_s3_deletes_total",
+		Help: "Number of local files removed because they were deleted from S3.",
+	})
+	syncErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "signage_sync_errors_total",
+		Help: "Number of failed S3 listings and downloads.",
+	})
+	mediaFilesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "signage_media_files",
+		Help: "Number of media files found by the last scan.",
+	})
+	lastSyncSuccessGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "signage_last_successful_sync_timestamp_seconds",
+		Help: "Unix time of the last S3 sync that completed without a listing error.",
+	})
+	lastSyncDurationGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "signage_last_sync_duration_seconds",
+		Help: "How long the last completed sync took.",
+	})
+	lastSyncBytesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "signage_last_sync_bytes",
+		Help: "Bytes downloaded by the last completed sync.",
+	})
+	syncBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "signage_sync_downloaded_bytes_total",
+		Help: "Bytes downloaded from storage, failed downloads included.",
+	})
+	buildInfoGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "signage_build_info",
+		Help: "Always 1, labeled with the build version.",
+	}, []string{"version"})
+)
+
+// registerDiskMetrics exports the space on the filesystem holding dir,
+// measured on every scrape
+func registerDiskMetrics(dir string) {
+	gauge := func(name, help string, value func(diskUsage) uint64) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, func() float64 {
+			usage, err := diskSpace(dir)
+			if err != nil {
+				return -1
+			}
+			return float64(value(usage))
+		})
+	}
+	gauge("signage_disk_total_bytes", "Size of the filesystem holding MEDIA_DIR, -1 if unknown.",
+		func(u diskUsage) uint64 { return u.Total })
+	gauge("signage_disk_used_bytes", "Bytes used on the filesystem holding MEDIA_DIR, -1 if unknown.",
+		func(u diskUsage) uint64 { return u.Used })
+	gauge("signage_disk_free_bytes", "Bytes available on the filesystem holding MEDIA_DIR, -1 if unknown.",
+		func(u diskUsage) uint64 { return u.Free })
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// nowPlaying is the last heartbeat the display sent for the media on screen
+type nowPlaying struct {
+	Index int       `json:"index"`
+	Name  string    `json:"name"`
+	URL   string    `json:"url"`
+	Time  time.Time `json:"time"`
+}
+
+// handleNowPlayingAPI records the display's position on POST and reports it
+// on GET, along with the age of the heartbeat so a stalled browser shows up.
+func (s *Server) handleNowPlayingAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var report nowPlaying
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&report); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		report.Time = time.Now()
+
+		s.stateMu.Lock()
+		s.nowPlaying = &report
+		for _, media := range s.currentMedia() {
+			if media.URL == report.URL {
+				s.recordPlay(media.Path, report.Time)
+				break
+			}
+		}
+		s.stateMu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet, http.MethodHead:
+		s.stateMu.Lock()
+		current := s.nowPlaying
+		s.stateMu.Unlock()
+
+		response := map[string]interface{}{
+			"playing": current != nil,
+		}
+		if current != nil {
+			response["index"] = current.Index
+			response["name"] = current.Name
+			response["url"] = current.URL
+			response["last_heartbeat"] = current.Time.Format(time.RFC3339)
+			response["seconds_since_heartbeat"] = int(time.Since(current.Time).Seconds())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
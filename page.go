@@ -0,0 +1,48 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"os"
+)
+
+// defaultIndex is the display page, INDEX_TEMPLATE replaces it with an
+// operator's own
+//
+//go:embed index.html
+var defaultIndex string
+
+// errorPage is shown when the browser ends up on an unknown path, black like
+// the display so a sign doesn't flash white. It goes back to the display
+// page after errorPageReload seconds.
+//
+//go:embed notfound.html
+var errorPageText string
+
+var errorPage = template.Must(template.New("notfound.html").Parse(errorPageText))
+
+const errorPageReload = 10
+
+// indexPage is the data the display page is rendered with
+type indexPage struct {
+	// Nonce must be set on every inline script and style for the CSP
+	Nonce string
+	// Config is embedded into the page script as JSON
+	Config PlayerConfig
+	// HLS is set when /hls.min.js is served
+	HLS bool
+}
+
+// loadIndexTemplate parses the page template at path, the embedded one if
+// path is empty
+func loadIndexTemplate(path string) (*template.Template, error) {
+	text := defaultIndex
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	return template.New("index.html").Parse(text)
+}
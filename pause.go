@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// handlePauseAPI reports whether the displays are paused on GET, pauses them
+// on POST and resumes them on DELETE. A paused display keeps the current
+// file on screen and stops rotating, but still refreshes the media list.
+// The flag is not persisted, a restart resumes playback.
+func (s *Server) handlePauseAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost, http.MethodDelete:
+		s.stateMu.Lock()
+		s.paused = r.Method == http.MethodPost
+		s.stateMu.Unlock()
+		s.events.publish(eventPauseChanged)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.stateMu.Lock()
+	paused := s.paused
+	s.stateMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"paused": paused,
+	})
+}
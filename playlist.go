@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var playlistOrders = []string{"name", "natural", "shuffle", "mtime", "mtime-desc"}
+
+func validatePlaylistOrder(order string) error {
+	for _, known := range playlistOrders {
+		if order == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown playlist order %q, expected one of %v", order, playlistOrders)
+}
+
+// sortMedia orders the playlist in place. Shuffling is seeded from the file
+// set, so the order stays the same between scans until files change.
+func sortMedia(files []MediaFile, order string) {
+	if order == "shuffle" {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Name < files[j].Name
+		})
+		rng := rand.New(rand.NewSource(playlistSeed(files)))
+		rng.Shuffle(len(files), func(i, j int) {
+			files[i], files[j] = files[j], files[i]
+		})
+		return
+	}
+	less := mediaLess(order)
+	sort.SliceStable(files, func(i, j int) bool {
+		return less(files[i], files[j])
+	})
+}
+
+// mediaLess compares two files by a sorting PLAYLIST_ORDER, files modified
+// at the same time are ordered by name
+func mediaLess(order string) func(a, b MediaFile) bool {
+	switch order {
+	case "natural":
+		return func(a, b MediaFile) bool { return naturalLess(a.Name, b.Name) }
+	case "mtime", "mtime-desc":
+		return func(a, b MediaFile) bool {
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime) == (order == "mtime")
+			}
+			return a.Name < b.Name
+		}
+	default:
+		return func(a, b MediaFile) bool { return a.Name < b.Name }
+	}
+}
+
+// describeOrder reports the order playlist, sent to the display, is in.
+// files are its files before weighting and listOrder the order the media
+// list was put in. Files no longer following a sorting order, as sources
+// were interleaved, add "+interleaved", and repeated files "+weighted".
+func describeOrder(files, playlist []MediaFile, listOrder string) string {
+	order := listOrder
+	if slices.Contains(playlistOrders, listOrder) && listOrder != "shuffle" {
+		less := mediaLess(listOrder)
+		for i := 1; i < len(files); i++ {
+			if less(files[i], files[i-1]) {
+				order += "+interleaved"
+				break
+			}
+		}
+	}
+	if len(playlist) > len(files) {
+		order += "+weighted"
+	}
+	return order
+}
+
+// interleaveSources takes one file from each source in turn, keeping the
+// order within a source, so no folder plays all of its files in a row.
+// Sources take turns in the order their first file appears.
+func interleaveSources(files []MediaFile) []MediaFile {
+	var sources []string
+	groups := make(map[string][]MediaFile)
+	for _, file := range files {
+		if _, ok := groups[file.Source]; !ok {
+			sources = append(sources, file.Source)
+		}
+		groups[file.Source] = append(groups[file.Source], file)
+	}
+
+	interleaved := make([]MediaFile, 0, len(files))
+	for len(interleaved) < len(files) {
+		for _, source := range sources {
+			if group := groups[source]; len(group) > 0 {
+				interleaved = append(interleaved, group[0])
+				groups[source] = group[1:]
+			}
+		}
+	}
+	return interleaved
+}
+
+// naturalLess compares names with runs of digits ordered by their numeric
+// value, so clip2.mp4 sorts before clip10.mp4. Equal numbers with different
+// zero padding fall back to the plain comparison.
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			startA, startB := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			numA := strings.TrimLeft(a[startA:i], "0")
+			numB := strings.TrimLeft(b[startB:j], "0")
+			if len(numA) != len(numB) {
+				return len(numA) < len(numB)
+			}
+			if numA != numB {
+				return numA < numB
+			}
+			continue
+		}
+		if a[i] != b[j] {
+			return a[i] < b[j]
+		}
+		i++
+		j++
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// weightMedia repeats each file as often as its weight, spreading the
+// copies evenly over the rotation instead of playing them back to back. The
+// weight comes from playlist.json, or the type's default weight.
+func weightMedia(files []MediaFile, imageWeight, videoWeight int) []MediaFile {
+	type slot struct {
+		position float64
+		index    int
+	}
+
+	var slots []slot
+	for i, file := range files {
+		weight := file.weight
+		if weight == 0 {
+			weight = videoWeight
+			if file.Type == "image" {
+				weight = imageWeight
+			}
+		}
+		weight = max(weight, 1)
+		for k := 0; k < weight; k++ {
+			slots = append(slots, slot{(float64(k) + float64(i)/float64(len(files))) / float64(weight), i})
+		}
+	}
+	sort.SliceStable(slots, func(i, j int) bool {
+		if slots[i].position != slots[j].position {
+			return slots[i].position < slots[j].position
+		}
+		return slots[i].index < slots[j].index
+	})
+
+	weighted := make([]MediaFile, len(slots))
+	for i, slot := range slots {
+		weighted[i] = files[slot.index]
+	}
+	return weighted
+}
+
+// playlistSeed hashes the names and modification times of the files, which
+// must already be in a deterministic order
+func playlistSeed(files []MediaFile) int64 {
+	h := fnv.New64a()
+	for _, file := range files {
+		fmt.Fprintf(h, "%s\x00%d\x00", file.Path, file.ModTime.UnixNano())
+	}
+	return int64(h.Sum64())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"clip2.mp4", "clip10.mp4", true},
+		{"clip10.mp4", "clip2.mp4", false},
+		{"clip1.mp4", "clip1.mp4", false},
+		{"clip02.mp4", "clip10.mp4", true},
+		{"clip010.mp4", "clip9.mp4", false},
+		// Equal numbers fall back to the plain comparison, "0" sorts first
+		{"clip02.mp4", "clip2.mp4", true},
+		{"clip2.mp4", "clip02.mp4", false},
+		// Upper case sorts before lower case, as in the name order
+		{"Clip10.mp4", "clip2.mp4", true},
+		{"clip2.mp4", "Clip10.mp4", false},
+		{"Clip2.mp4", "Clip10.mp4", true},
+		// Equal up to a number, then the rest decides
+		{"clip2.mp4", "clip2a.mp4", true},
+		{"clip2b.mp4", "clip2a.mp4", false},
+		{"clip2_1.mp4", "clip2_10.mp4", true},
+		{"clip.mp4", "clip1.mp4", true},
+		{"2024-3-promo.mp4", "2024-12-promo.mp4", true},
+	}
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSortMediaNatural(t *testing.T) {
+	files := mediaFiles("clip10.mp4", "clip2.mp4", "clip1.mp4", "clip02.mp4", "intro.mp4")
+	sortMedia(files, "natural")
+
+	want := []string{"clip1.mp4", "clip02.mp4", "clip2.mp4", "clip10.mp4", "intro.mp4"}
+	if got := names(files); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDescribeOrder(t *testing.T) {
+	sorted := mediaFiles("a.mp4", "b.jpg", "c.mp4", "d.jpg")
+	for i := range sorted {
+		sorted[i].Type = "video"
+		if i%2 == 1 {
+			sorted[i].Type = "image"
+		}
+	}
+	interleaved := []MediaFile{sorted[0], sorted[2], sorted[1], sorted[3]}
+
+	tests := []struct {
+		files       []MediaFile
+		imageWeight int
+		listOrder   string
+		want        string
+	}{
+		{sorted, 1, "name", "name"},
+		{interleaved, 1, "name", "name+interleaved"},
+		{sorted, 2, "name", "name+weighted"},
+		{interleaved, 2, "name", "name+interleaved+weighted"},
+		{interleaved, 1, "shuffle", "shuffle"},
+		{interleaved, 2, "manual", "manual+weighted"},
+	}
+	for _, tt := range tests {
+		playlist := weightMedia(tt.files, tt.imageWeight, 1)
+		if got := describeOrder(tt.files, playlist, tt.listOrder); got != tt.want {
+			t.Errorf("describeOrder(%v, %q) = %q, want %q", names(playlist), tt.listOrder, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const playlistFileName = "playlist.json"
+
+// playlistEntry restricts when a file in MediaDir is shown. Empty times
+// mean the whole day and no days mean every day. MaxDurationSeconds cuts a
+// video short, or replaces IMAGE_DURATION_SECONDS for an image. Weight
+// overrides IMAGE_WEIGHT or VIDEO_WEIGHT for the file and Volume, from 0 to
+// 1, scales the display volume while it plays.
+type playlistEntry struct {
+	Filename           string   `json:"filename"`
+	StartTime          string   `json:"startTime"`
+	EndTime            string   `json:"endTime"`
+	Days               []string `json:"days"`
+	MaxDurationSeconds int      `json:"maxDurationSeconds"`
+	Weight             int      `json:"weight"`
+	Volume             *float64 `json:"volume"`
+}
+
+// playlistFile is the optional playlist.json in MediaDir. Listed files play
+// in the order of the entries, files that are not listed are appended after
+// them or, with "unlisted": "exclude", never shown.
+type playlistFile struct {
+	Unlisted string          `json:"unlisted"`
+	Entries  []playlistEntry `json:"entries"`
+}
+
+// loadPlaylistFile reads playlist.json from dir, returning nil if there is none
+func loadPlaylistFile(dir string) (*playlistFile, error) {
+	data, err := os.ReadFile(filepath.Join(dir, playlistFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var playlist playlistFile
+	if err := json.Unmarshal(data, &playlist); err != nil {
+		return nil, err
+	}
+	switch playlist.Unlisted {
+	case "", "append", "exclude":
+	default:
+		return nil, fmt.Errorf("invalid unlisted mode %q, expected append or exclude", playlist.Unlisted)
+	}
+	return &playlist, nil
+}
+
+// schedule converts the entry's times and days into a Schedule
+func (e playlistEntry) schedule() (Schedule, error) {
+	window := activeWindow{
+		days: [7]bool{true, true, true, true, true, true, true},
+		end:  24 * 60,
+	}
+	if len(e.Days) > 0 {
+		days, err := parseDays(strings.Join(e.Days, ","))
+		if err != nil {
+			return Schedule{}, err
+		}
+		window.days = days
+	}
+
+	var err error
+	if e.StartTime != "" {
+		if window.start, err = parseClock(e.StartTime); err != nil {
+			return Schedule{}, err
+		}
+	}
+	if e.EndTime != "" {
+		if window.end, err = parseClock(e.EndTime); err != nil {
+			return Schedule{}, err
+		}
+	}
+	if window.start == window.end {
+		return Schedule{}, fmt.Errorf("empty time range %s-%s", e.StartTime, e.EndTime)
+	}
+	return Schedule{windows: []activeWindow{window}}, nil
+}
+
+// apply orders files by the playlist and attaches each entry's schedule to
+// its file. Files stay in the list even when excluded so the sync still
+// sees them. Entries are matched against paths relative to mediaDir, only
+// the first entry for a file counts.
+func (p *playlistFile) apply(files []MediaFile, mediaDir string) error {
+	schedules := make([]Schedule, len(p.Entries))
+	rank := make(map[string]int, len(p.Entries))
+	for i, entry := range p.Entries {
+		schedule, err := entry.schedule()
+		if err != nil {
+			return fmt.Errorf("entry %q: %w", entry.Filename, err)
+		}
+		if entry.MaxDurationSeconds < 0 {
+			return fmt.Errorf("entry %q: negative maxDurationSeconds", entry.Filename)
+		}
+		if entry.Weight < 0 {
+			return fmt.Errorf("entry %q: negative weight", entry.Filename)
+		}
+		if entry.Volume != nil && (*entry.Volume < 0 || *entry.Volume > 1) {
+			return fmt.Errorf("entry %q: volume must be between 0 and 1", entry.Filename)
+		}
+		schedules[i] = schedule
+		name := filepath.ToSlash(filepath.Clean(entry.Filename))
+		if _, ok := rank[name]; !ok {
+			rank[name] = i
+		}
+	}
+
+	fileRank := func(file MediaFile) (int, bool) {
+		if file.root != 0 {
+			return len(p.Entries), false
+		}
+		relPath, err := filepath.Rel(mediaDir, file.Path)
+		if err != nil {
+			return len(p.Entries), false
+		}
+		i, ok := rank[filepath.ToSlash(relPath)]
+		if !ok {
+			return len(p.Entries), false
+		}
+		return i, true
+	}
+
+	for i := range files {
+		if index, ok := fileRank(files[i]); ok {
+			files[i].schedule = &schedules[index]
+			files[i].MaxDuration = p.Entries[index].MaxDurationSeconds
+			files[i].weight = p.Entries[index].Weight
+			files[i].Volume = p.Entries[index].Volume
+		} else if p.Unlisted == "exclude" {
+			files[i].excluded = true
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, _ := fileRank(files[i])
+		b, _ := fileRank(files[j])
+		return a < b
+	})
+	return nil
+}
+
+// activeMedia returns the files whose playlist schedule allows them at t
+func activeMedia(files []MediaFile, t time.Time) []MediaFile {
+	active := make([]MediaFile, 0, len(files))
+	for _, file := range files {
+		if file.excluded {
+			continue
+		}
+		if file.schedule == nil || file.schedule.IsActive(t) {
+			active = append(active, file)
+		}
+	}
+	return active
+}
+
+// nextMediaChange returns the next time after t at which a file enters or
+// leaves the playlist, or the zero time if none does
+func nextMediaChange(files []MediaFile, t time.Time) time.Time {
+	var next time.Time
+	for _, file := range files {
+		if file.schedule == nil {
+			continue
+		}
+		if change := file.schedule.NextChange(t); !change.IsZero() && (next.IsZero() || change.Before(next)) {
+			next = change
+		}
+	}
+	return next
+}
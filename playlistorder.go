@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const playlistOrderFileName = ".playlist-order.json"
+
+// playlistOrder is the order set through PUT /api/playlist, by file names
+// relative to their media directory
+type playlistOrder struct {
+	Files []string `json:"files"`
+}
+
+// loadPlaylistOrder reads the persisted order from dir, none if it wasn't
+// set
+func loadPlaylistOrder(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, playlistOrderFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var order playlistOrder
+	if err := json.Unmarshal(data, &order); err != nil {
+		return nil, err
+	}
+	return order.Files, nil
+}
+
+// savePlaylistOrder persists the order, removing the file for an empty one
+func savePlaylistOrder(dir string, files []string) error {
+	path := filepath.Join(dir, playlistOrderFileName)
+	if len(files) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(playlistOrder{Files: files})
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// applyPlaylistOrder moves the listed files to the front in the given
+// order, the others follow in their current order
+func applyPlaylistOrder(files []MediaFile, order []string, dirs []string) {
+	rank := make(map[string]int, len(order))
+	for i, name := range order {
+		rank[name] = i
+	}
+	fileRank := func(file MediaFile) int {
+		relPath, err := filepath.Rel(dirs[file.root], file.Path)
+		if err != nil {
+			return len(order)
+		}
+		if i, ok := rank[filepath.ToSlash(relPath)]; ok {
+			return i
+		}
+		return len(order)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return fileRank(files[i]) < fileRank(files[j])
+	})
+}
+
+// handlePlaylistAPI returns the manual order on GET, replaces it on PUT with
+// a {"files": ["a.mp4", "promo/b.jpg"]} body and clears it on DELETE. The
+// order replaces PLAYLIST_ORDER until cleared, a playlist.json still takes
+// precedence over it.
+func (s *Server) handlePlaylistAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut, http.MethodDelete:
+		var order playlistOrder
+		if r.Method == http.MethodPut {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&order); err != nil || len(order.Files) == 0 {
+				writeJSONError(w, http.StatusBadRequest, "expected a JSON body with a list of files")
+				return
+			}
+			s.scanMedia()
+			seen := make(map[string]bool, len(order.Files))
+			for i, name := range order.Files {
+				media, ok := s.findMedia(name)
+				if !ok {
+					writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("no such media file %q", name))
+					return
+				}
+				relPath, _ := filepath.Rel(s.config.MediaDirs[media.root], media.Path)
+				order.Files[i] = filepath.ToSlash(relPath)
+				if seen[order.Files[i]] {
+					writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("%q is listed twice", name))
+					return
+				}
+				seen[order.Files[i]] = true
+			}
+		}
+		if err := savePlaylistOrder(s.config.MediaDir, order.Files); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		s.stateMu.Lock()
+		s.order = order.Files
+		s.stateMu.Unlock()
+		// Publishes the new order to the displays
+		s.scanMedia()
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.stateMu.Lock()
+	files := s.order
+	s.stateMu.Unlock()
+	if files == nil {
+		files = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playlistOrder{Files: files})
+}
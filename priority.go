@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// priorityItem is a file every display plays once, as soon as its current
+// item ends, before resuming its rotation. The ID tells displays apart
+// requests to play the same file again.
+type priorityItem struct {
+	ID       int64  `json:"id"`
+	Filename string `json:"filename"`
+}
+
+// findMedia returns the file with the given path relative to its media
+// directory, preferring the sync target when several directories have one
+func (s *Server) findMedia(filename string) (MediaFile, bool) {
+	name := filepath.ToSlash(filepath.Clean(filename))
+	var found MediaFile
+	ok := false
+	for _, media := range s.currentMedia() {
+		relPath, err := filepath.Rel(s.config.MediaDirs[media.root], media.Path)
+		if err == nil && filepath.ToSlash(relPath) == name && (!ok || media.root < found.root) {
+			found, ok = media, true
+		}
+	}
+	return found, ok
+}
+
+// handlePriorityAPI reports the priority item on GET, sets it on POST with a
+// {"filename": "..."} body naming a media file, and clears it on DELETE.
+func (s *Server) handlePriorityAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		var request struct {
+			Filename string `json:"filename"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&request); err != nil || request.Filename == "" {
+			writeJSONError(w, http.StatusBadRequest, "expected a JSON body with a filename")
+			return
+		}
+		s.scanMedia()
+		if _, ok := s.findMedia(request.Filename); !ok {
+			writeJSONError(w, http.StatusNotFound, "no such file in the media directory")
+			return
+		}
+
+		s.stateMu.Lock()
+		s.priority = &priorityItem{ID: time.Now().UnixMilli(), Filename: request.Filename}
+		s.stateMu.Unlock()
+		s.events.publish(eventPriorityChanged)
+	case http.MethodDelete:
+		s.stateMu.Lock()
+		s.priority = nil
+		s.stateMu.Unlock()
+		s.events.publish(eventPriorityChanged)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.stateMu.Lock()
+	priority := s.priority
+	s.stateMu.Unlock()
+
+	response := map[string]interface{}{
+		"priority": nil,
+	}
+	if priority != nil {
+		if media, ok := s.findMedia(priority.Filename); ok {
+			response["priority"] = map[string]interface{}{
+				"id":       priority.ID,
+				"filename": priority.Filename,
+				"media":    media,
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isoMediaExtensions are the containers whose duration is read from the
+// mvhd box directly, others need ffprobe
+var isoMediaExtensions = map[string]bool{".mp4": true, ".m4v": true, ".mov": true, ".3gp": true}
+
+var errNoDuration = errors.New("duration not found")
+
+// probeDuration returns the length of a video in seconds, reading MP4 style
+// headers itself and falling back to ffprobe when it is installed
+func probeDuration(path string, size int64) (float64, error) {
+	if isoMediaExtensions[strings.ToLower(filepath.Ext(path))] {
+		if duration, err := mp4Duration(path, size); err == nil {
+			return duration, nil
+		}
+	}
+	return ffprobeDuration(path)
+}
+
+// mp4Duration reads the movie header box, moov/mvhd, of an ISO media file
+func mp4Duration(path string, size int64) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	moov, moovSize, err := findBox(file, 0, size, "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhd, _, err := findBox(file, moov, moov+moovSize, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 32)
+	if _, err := file.ReadAt(header, mvhd); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	var timescale uint32
+	var duration uint64
+	if header[0] == 1 {
+		// Version 1 has 64 bit creation and modification times and duration
+		timescale = binary.BigEndian.Uint32(header[20:24])
+		duration = binary.BigEndian.Uint64(header[24:32])
+	} else {
+		timescale = binary.BigEndian.Uint32(header[12:16])
+		duration = uint64(binary.BigEndian.Uint32(header[16:20]))
+	}
+	if timescale == 0 {
+		return 0, errNoDuration
+	}
+	return float64(duration) / float64(timescale), nil
+}
+
+// findBox looks for a box of the given type between start and end and
+// returns the offset and size of its payload
+func findBox(file *os.File, start, end int64, boxType string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0: // Extends to the end
+			boxSize = end - offset
+		case 1: // 64 bit size follows the type
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, 0, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize {
+			return 0, 0, errNoDuration
+		}
+		if string(header[4:8]) == boxType {
+			return offset + headerSize, boxSize - headerSize, nil
+		}
+		offset += boxSize
+	}
+	return 0, 0, errNoDuration
+}
+
+// ffprobeDuration asks ffprobe for the duration of any container it knows
+func ffprobeDuration(path string) (float64, error) {
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, ffprobe, "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+}
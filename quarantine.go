@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+)
+
+const (
+	// quarantineAfter is how many syncs in a row a key may fail to
+	// download before it is skipped
+	quarantineAfter = 3
+	// A quarantined key is retried after quarantineBase, doubling with
+	// every further failure up to quarantineMax
+	quarantineBase = time.Hour
+	quarantineMax  = 24 * time.Hour
+)
+
+// keyFailure tracks an object that keeps failing to download. A new ETag
+// means the object was replaced, so it gets another chance right away.
+type keyFailure struct {
+	Key       string    `json:"key"`
+	ETag      string    `json:"-"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error"`
+	Until     time.Time `json:"until,omitempty"`
+}
+
+// skipQuarantined drops the jobs for keys that are still quarantined
+func (s *Server) skipQuarantined(jobs []downloadJob, now time.Time) []downloadJob {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	kept := jobs[:0]
+	for _, job := range jobs {
+		failure, ok := s.failures[job.obj.Key]
+		if ok && failure.ETag != job.obj.ETag {
+			delete(s.failures, job.obj.Key)
+		} else if ok && now.Before(failure.Until) {
+			slog.Debug("Skipping quarantined key", "event", "quarantine_skip", "key", job.obj.Key, "until", failure.Until)
+			continue
+		}
+		kept = append(kept, job)
+	}
+	return kept
+}
+
+// recordDownload updates the failure count of the job's key, quarantining
+// it once it failed quarantineAfter times in a row
+func (s *Server) recordDownload(job downloadJob, err error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if err == nil {
+		delete(s.failures, job.obj.Key)
+		return
+	}
+	failure, ok := s.failures[job.obj.Key]
+	if !ok || failure.ETag != job.obj.ETag {
+		failure = &keyFailure{Key: job.obj.Key, ETag: job.obj.ETag}
+		s.failures[job.obj.Key] = failure
+	}
+	failure.Failures++
+	failure.LastError = err.Error()
+	if failure.Failures >= quarantineAfter {
+		backoff := quarantineBase << min(failure.Failures-quarantineAfter, 5)
+		failure.Until = time.Now().Add(min(backoff, quarantineMax))
+		slog.Warn("Quarantined key after repeated download failures", "event", "quarantine",
+			"key", job.obj.Key, "failures", failure.Failures, "until", failure.Until, "error", err)
+	}
+}
+
+// quarantinedKeys lists the keys currently skipped by the sync, by key
+func (s *Server) quarantinedKeys() []keyFailure {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	keys := []keyFailure{}
+	now := time.Now()
+	for _, failure := range s.failures {
+		if now.Before(failure.Until) {
+			keys = append(keys, *failure)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	return keys
+}
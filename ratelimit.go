@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// clientIdle is how long a client's bucket is kept after its last request
+const clientIdle = 3 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// apiLimiter holds a token bucket per client address, refilled at the
+// configured requests per second with a burst of twice that, at least 10, so
+// the display page loading its state at once isn't throttled
+type apiLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+func newAPILimiter(perSecond int) *apiLimiter {
+	return &apiLimiter{
+		limit:   rate.Limit(perSecond),
+		burst:   max(2*perSecond, 10),
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+func (l *apiLimiter) allow(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		for address, c := range l.clients {
+			if now.Sub(c.lastSeen) > clientIdle {
+				delete(l.clients, address)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+	return c.limiter.AllowN(now, 1)
+}
+
+// withRateLimit answers /api/ requests over API_RATE_LIMIT per second from
+// one client with 429, the page and /media/ are never limited. A limit of 0
+// disables it.
+func withRateLimit(perSecond int, next http.Handler) http.Handler {
+	if perSecond <= 0 {
+		return next
+	}
+	limiter := newAPILimiter(perSecond)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if !limiter.allow(client, time.Now()) {
+			w.Header().Set("Retry-After", "1")
+			writeJSONError(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
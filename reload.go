@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+)
+
+// reloadOnHangup re-reads the --config file on every SIGHUP and applies the
+// settings that can change while running, the display keeps playing.
+// fileKeys are the variables the file set at startup.
+func (s *Server) reloadOnHangup(ctx context.Context, path string, fileKeys map[string]bool) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-hangup:
+		case <-ctx.Done():
+			return
+		}
+		if path == "" {
+			log.Printf("Ignoring SIGHUP, no --config file to reload")
+			continue
+		}
+
+		keys, err := applyConfigFile(path, fileKeys)
+		if err != nil {
+			log.Printf("Failed to reload %s, keeping the current settings: %v", path, err)
+			continue
+		}
+		fileKeys = keys
+		s.applyReload(loadConfig())
+		log.Printf("Reloaded %s", path)
+	}
+}
+
+// applyReload switches to the sync interval, playlist order and ticker text
+// of next. Settings that only take effect on a restart are logged when they
+// changed.
+func (s *Server) applyReload(next AppConfig) {
+	restartOnly := []struct {
+		name    string
+		changed bool
+	}{
+		{"PORT", next.Port != s.config.Port},
+		{"STORAGE_BACKEND", next.StorageBackend != s.config.StorageBackend},
+		{"S3_BUCKET", next.S3Bucket != s.config.S3Bucket},
+		{"GCS_BUCKET", next.GCSBucket != s.config.GCSBucket},
+		{"S3_PREFIX", !slices.Equal(next.S3Prefixes, s.config.S3Prefixes)},
+		{"MEDIA_DIR", !slices.Equal(next.MediaDirs, s.config.MediaDirs)},
+	}
+	for _, setting := range restartOnly {
+		if setting.changed {
+			log.Printf("Warning: %s changed, it takes effect after a restart", setting.name)
+		}
+	}
+
+	if next.SyncInterval <= 0 {
+		log.Printf("Invalid SYNC_INTERVAL_MINUTES, keeping the current interval")
+		next.SyncInterval = s.syncInterval()
+	}
+	if err := validatePlaylistOrder(next.PlaylistOrder); err != nil {
+		log.Printf("Invalid PLAYLIST_ORDER, keeping the current order: %v", err)
+		next.PlaylistOrder = ""
+	}
+
+	s.stateMu.Lock()
+	intervalChanged := next.SyncInterval != s.syncEvery
+	s.syncEvery = next.SyncInterval
+	orderChanged := next.PlaylistOrder != "" && next.PlaylistOrder != s.sortOrder
+	if orderChanged {
+		s.sortOrder = next.PlaylistOrder
+	}
+	tickerChanged := next.TickerText != "" && next.TickerText != s.ticker
+	s.stateMu.Unlock()
+
+	if intervalChanged {
+		log.Printf("Sync interval is now %v", next.SyncInterval)
+		select {
+		case s.reloaded <- struct{}{}:
+		default:
+		}
+	}
+	if orderChanged {
+		log.Printf("Playlist order is now %s", next.PlaylistOrder)
+		s.scanMedia()
+	}
+	if tickerChanged {
+		if err := s.setTicker(next.TickerText); err != nil {
+			log.Printf("Failed to save ticker message: %v", err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// renditionPattern matches a resolution suffix such as foo_1080.mp4,
+// foo_720p.webm or foo_4k.mp4
+var renditionPattern = regexp.MustCompile(`(?i)^(.+)_(\d{3,4}p?|[248]k|hd|fhd|uhd)$`)
+
+// renditionHeights are the heights a suffix without "p" may name, so
+// numbered files like clip_001.mp4 or promo_2024.mp4 aren't renditions
+var renditionHeights = map[int]bool{
+	240: true, 360: true, 480: true, 720: true, 1080: true, 1440: true, 2160: true,
+}
+
+var renditionAliases = map[string]int{
+	"hd": 720, "fhd": 1080, "2k": 1440, "uhd": 2160, "4k": 2160, "8k": 4320,
+}
+
+// parseRendition splits a file name into the clip it belongs to and the
+// vertical resolution it was rendered at. Files without a resolution suffix
+// return a height of 0.
+func parseRendition(name string) (string, int) {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	match := renditionPattern.FindStringSubmatch(base)
+	if match == nil {
+		return base, 0
+	}
+	height, err := parseResolution(match[2])
+	if err != nil {
+		return base, 0
+	}
+	suffix := strings.ToLower(match[2])
+	if _, alias := renditionAliases[suffix]; !alias && !strings.HasSuffix(suffix, "p") && !renditionHeights[height] {
+		return base, 0
+	}
+	return match[1], height
+}
+
+// parseResolution accepts "1080", "1080p", "4k" or "1920x1080" and returns
+// the vertical resolution
+func parseResolution(text string) (int, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if height, ok := renditionAliases[text]; ok {
+		return height, nil
+	}
+	if _, h, ok := strings.Cut(text, "x"); ok {
+		text = h
+	}
+	height, err := strconv.Atoi(strings.TrimSuffix(text, "p"))
+	if err != nil || height <= 0 {
+		return 0, fmt.Errorf("invalid resolution %q", text)
+	}
+	return height, nil
+}
+
+// selectRenditions keeps a single rendition of each clip: the largest one
+// that fits the target height, or the smallest one when none fits. Files
+// without a resolution suffix, and clips with only one rendition, are kept
+// as is. The order of the playlist is preserved.
+func selectRenditions(files []MediaFile, target int) []MediaFile {
+	if target <= 0 {
+		return files
+	}
+
+	best := make(map[string]int)
+	for i, file := range files {
+		if file.Resolution == 0 {
+			continue
+		}
+		key := renditionGroup(file)
+		current, ok := best[key]
+		if !ok || betterRendition(file.Resolution, files[current].Resolution, target) {
+			best[key] = i
+		}
+	}
+
+	selected := make([]MediaFile, 0, len(files))
+	for i, file := range files {
+		if file.Resolution == 0 || best[renditionGroup(file)] == i {
+			selected = append(selected, file)
+		}
+	}
+	return selected
+}
+
+func renditionGroup(file MediaFile) string {
+	base, _ := parseRendition(file.Name)
+	return strconv.Itoa(file.root) + ":" + filepath.Join(filepath.Dir(file.Path), base)
+}
+
+// betterRendition reports whether candidate fits target better than current
+func betterRendition(candidate, current, target int) bool {
+	candidateFits, currentFits := candidate <= target, current <= target
+	switch {
+	case candidateFits && currentFits:
+		return candidate > current
+	case candidateFits != currentFits:
+		return candidateFits
+	default:
+		return candidate < current
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseRendition(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		height int
+	}{
+		{"foo_720.mp4", "foo", 720},
+		{"foo_1080p.webm", "foo", 1080},
+		{"foo_4k.mp4", "foo", 2160},
+		{"foo_UHD.mp4", "foo", 2160},
+		{"foo.mp4", "foo", 0},
+		{"summer_sale.mp4", "summer_sale", 0},
+		{"clip_12.mp4", "clip_12", 0},
+		{"clip_001.mp4", "clip_001", 0},
+		{"slide_002.jpg", "slide_002", 0},
+		{"promo_2024.mp4", "promo_2024", 0},
+		{"promo_2024p.mp4", "promo", 2024},
+		{"foo_480.mp4", "foo", 480},
+	}
+	for _, tt := range tests {
+		base, height := parseRendition(tt.name)
+		if base != tt.base || height != tt.height {
+			t.Errorf("parseRendition(%q) = %q, %d, want %q, %d", tt.name, base, height, tt.base, tt.height)
+		}
+	}
+}
+
+func TestSelectRenditions(t *testing.T) {
+	renditions := []string{"foo_720.mp4", "foo_1080.mp4", "foo_2160.mp4", "bar.mp4"}
+	tests := []struct {
+		target int
+		want   []string
+	}{
+		{0, renditions},
+		{480, []string{"foo_720.mp4", "bar.mp4"}},
+		{720, []string{"foo_720.mp4", "bar.mp4"}},
+		{1080, []string{"foo_1080.mp4", "bar.mp4"}},
+		{1440, []string{"foo_1080.mp4", "bar.mp4"}},
+		{2160, []string{"foo_2160.mp4", "bar.mp4"}},
+		{4320, []string{"foo_2160.mp4", "bar.mp4"}},
+	}
+	for _, tt := range tests {
+		if got := names(selectRenditions(mediaFiles(renditions...), tt.target)); !slices.Equal(got, tt.want) {
+			t.Errorf("target %d: got %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestSelectRenditionsTies(t *testing.T) {
+	// The same height twice keeps the first in playlist order
+	files := mediaFiles("foo_1080.mp4", "foo_1080p.webm", "foo_720.mp4")
+	if got, want := names(selectRenditions(files, 1080)), []string{"foo_1080.mp4"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Clips are grouped by folder, equal names elsewhere are other clips
+	files = mediaFiles("a/foo_720.mp4", "b/foo_1080.mp4")
+	if got, want := names(selectRenditions(files, 1080)), []string{"foo_720.mp4", "foo_1080.mp4"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSelectRenditionsNumberedFiles(t *testing.T) {
+	files := mediaFiles("clip_001.mp4", "clip_002.mp4", "promo_2024.mp4", "promo_2025.mp4")
+	if got, want := names(selectRenditions(files, 1080)), names(files); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBetterRendition(t *testing.T) {
+	tests := []struct {
+		candidate, current, target int
+		want                       bool
+	}{
+		{1080, 720, 1080, true},
+		{720, 1080, 1080, false},
+		{1080, 2160, 1080, true},
+		{2160, 1080, 1080, false},
+		{1080, 2160, 720, true},
+		{2160, 1080, 720, false},
+		{1080, 1080, 1080, false},
+	}
+	for _, tt := range tests {
+		if got := betterRendition(tt.candidate, tt.current, tt.target); got != tt.want {
+			t.Errorf("betterRendition(%d, %d, %d) = %v, want %v", tt.candidate, tt.current, tt.target, got, tt.want)
+		}
+	}
+}
+
+// mediaFiles builds files in one media directory from their relative paths
+func mediaFiles(paths ...string) []MediaFile {
+	files := make([]MediaFile, len(paths))
+	for i, path := range paths {
+		name := path[strings.LastIndex(path, "/")+1:]
+		_, resolution := parseRendition(name)
+		files[i] = MediaFile{Name: name, Path: "/media/" + path, Resolution: resolution}
+	}
+	return files
+}
+
+func names(files []MediaFile) []string {
+	result := make([]string, len(files))
+	for i, file := range files {
+		result[i] = file.Name
+	}
+	return result
+}
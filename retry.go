@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// withRetry calls fn until it succeeds, doubling the delay between attempts,
+// and gives up after the given number of retries. Missing objects and
+// cancellation are returned right away, retrying can't fix them.
+func withRetry(ctx context.Context, retries int, operation string, fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !retryable(ctx, err) {
+			return err
+		}
+
+		slog.Warn("Storage operation failed, retrying", "event", "retry", "operation", operation,
+			"attempt", attempt+1, "delay_ms", delay.Milliseconds(), "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	return !errors.Is(err, errNotFound)
+}
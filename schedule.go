@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// activeWindow is a daily time range, in minutes since midnight, on a set of
+// weekdays. A window whose end is before its start runs past midnight and
+// belongs to the day it starts on.
+type activeWindow struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+// Schedule holds the operating hours of the display. An empty schedule is
+// always active.
+type Schedule struct {
+	windows []activeWindow
+}
+
+// parseSchedule parses a list of windows separated by ";", each an optional
+// day list followed by a time range, e.g. "Mon-Fri 08:00-20:00; Sat,Sun 10:00-16:00".
+func parseSchedule(spec string) (Schedule, error) {
+	var schedule Schedule
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.Fields(part)
+		if len(fields) > 2 {
+			return Schedule{}, fmt.Errorf("invalid window %q", part)
+		}
+
+		var window activeWindow
+		if len(fields) == 2 {
+			days, err := parseDays(fields[0])
+			if err != nil {
+				return Schedule{}, err
+			}
+			window.days = days
+		} else {
+			window.days = [7]bool{true, true, true, true, true, true, true}
+		}
+
+		startText, endText, ok := strings.Cut(fields[len(fields)-1], "-")
+		if !ok {
+			return Schedule{}, fmt.Errorf("invalid time range %q", fields[len(fields)-1])
+		}
+		var err error
+		if window.start, err = parseClock(startText); err != nil {
+			return Schedule{}, err
+		}
+		if window.end, err = parseClock(endText); err != nil {
+			return Schedule{}, err
+		}
+		if window.start == window.end {
+			return Schedule{}, fmt.Errorf("empty time range %q", fields[len(fields)-1])
+		}
+
+		schedule.windows = append(schedule.windows, window)
+	}
+	return schedule, nil
+}
+
+// dailySchedule turns SCHEDULE_ON and SCHEDULE_OFF into the equivalent
+// ACTIVE_HOURS window, an off time before the on time runs past midnight
+func dailySchedule(cfg AppConfig) (string, error) {
+	if cfg.ActiveHours != "" {
+		return "", fmt.Errorf("cannot be combined with ACTIVE_HOURS")
+	}
+	if cfg.ScheduleOn == "" || cfg.ScheduleOff == "" {
+		return "", fmt.Errorf("both times must be set")
+	}
+	for _, text := range []string{cfg.ScheduleOn, cfg.ScheduleOff} {
+		if _, err := parseClock(text); err != nil {
+			return "", err
+		}
+	}
+	return cfg.ScheduleOn + "-" + cfg.ScheduleOff, nil
+}
+
+func parseDays(text string) ([7]bool, error) {
+	var days [7]bool
+	for _, item := range strings.Split(text, ",") {
+		from, to, isRange := strings.Cut(strings.ToLower(item), "-")
+		first, ok := weekdayNames[from]
+		if !ok {
+			return days, fmt.Errorf("invalid day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdayNames[to]; !ok {
+				return days, fmt.Errorf("invalid day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(text string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(text))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", text)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// IsEmpty reports whether no windows are configured
+func (sc Schedule) IsEmpty() bool {
+	return len(sc.windows) == 0
+}
+
+// IsActive reports whether t falls inside any window, using the wall clock
+// of t's location so DST transitions are handled by the time package.
+func (sc Schedule) IsActive(t time.Time) bool {
+	if sc.IsEmpty() {
+		return true
+	}
+	for _, window := range sc.windows {
+		for _, span := range window.spans(t, 1) {
+			if !t.Before(span[0]) && t.Before(span[1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextChange returns the next time after t at which IsActive flips, or the
+// zero time if the schedule never changes.
+func (sc Schedule) NextChange(t time.Time) time.Time {
+	if sc.IsEmpty() {
+		return time.Time{}
+	}
+
+	var boundaries []time.Time
+	for _, window := range sc.windows {
+		for _, span := range window.spans(t, 8) {
+			boundaries = append(boundaries, span[0], span[1])
+		}
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i].Before(boundaries[j])
+	})
+
+	current := sc.IsActive(t)
+	for _, boundary := range boundaries {
+		if boundary.After(t) && sc.IsActive(boundary) != current {
+			return boundary
+		}
+	}
+	return time.Time{}
+}
+
+// spans returns the concrete start/end times of the window for the days
+// around t, from the day before up to the given number of days ahead.
+func (w activeWindow) spans(t time.Time, daysAhead int) [][2]time.Time {
+	var spans [][2]time.Time
+	year, month, day := t.Date()
+	for offset := -1; offset <= daysAhead; offset++ {
+		midnight := time.Date(year, month, day+offset, 0, 0, 0, 0, t.Location())
+		if !w.days[midnight.Weekday()] {
+			continue
+		}
+		start := time.Date(year, month, day+offset, w.start/60, w.start%60, 0, 0, t.Location())
+		endDay := day + offset
+		if w.end < w.start {
+			endDay++
+		}
+		end := time.Date(year, month, endDay, w.end/60, w.end%60, 0, 0, t.Location())
+		spans = append(spans, [2]time.Time{start, end})
+	}
+	return spans
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// defaultCSP only allows the inline script and style that carry the
+// per-request nonce, everything else must come from the server itself.
+const defaultCSP = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; " +
+	"img-src 'self' data:; media-src 'self' blob:; connect-src 'self'; object-src 'none'; " +
+	"base-uri 'none'; frame-ancestors 'none'"
+
+type nonceKey struct{}
+
+// playerEndpoints are the API routes the display page itself reads, they
+// stay open for GET so the screen works without knowing the token
+var playerEndpoints = map[string]bool{
+	"/api/media":    true,
+	"/api/schedule": true,
+	"/api/ticker":   true,
+	"/api/priority": true,
+	"/api/volume":   true,
+	"/api/pause":    true,
+}
+
+// playerReports are the API routes the display page posts its state to,
+// they stay open for POST for the same reason
+var playerReports = map[string]bool{
+	"/api/now-playing": true,
+}
+
+// securityHeaders adds the security headers to every response and makes a
+// fresh CSP nonce available to handlers through the request context.
+func securityHeaders(cfg AppConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "no-referrer")
+		if cfg.FrameOptions != "" {
+			header.Set("X-Frame-Options", cfg.FrameOptions)
+		}
+
+		if cfg.CSP != "" {
+			nonce, err := newNonce()
+			if err != nil {
+				log.Printf("Failed to generate CSP nonce: %v", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			header.Set("Content-Security-Policy", strings.ReplaceAll(cfg.CSP, "{nonce}", nonce))
+			r = r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cspNonce returns the nonce generated for the request, if any
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceKey{}).(string)
+	return nonce
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// requireToken rejects /api/ requests without the configured bearer token or
+// basic auth credentials, either is accepted when both are set. Without
+// API_TOKEN or BASIC_AUTH_USER everything stays open, as before.
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authConfigured() || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if (readOnly && playerEndpoints[r.URL.Path]) || (r.Method == http.MethodPost && playerReports[r.URL.Path]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !s.validToken(r) && !s.validBasicAuth(r) {
+			if s.config.APIToken != "" {
+				w.Header().Add("WWW-Authenticate", "Bearer")
+			}
+			if s.config.BasicAuthUser != "" {
+				w.Header().Add("WWW-Authenticate", `Basic realm="digital-signage", charset="UTF-8"`)
+			}
+			writeJSONError(w, http.StatusUnauthorized, "invalid or missing credentials")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validToken reports whether the request carries the configured API token,
+// compared in constant time
+func (s *Server) validToken(r *http.Request) bool {
+	if s.config.APIToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.APIToken)) == 1
+}
+
+// validBasicAuth reports whether the request carries the configured basic
+// auth credentials, both compared in constant time
+func (s *Server) validBasicAuth(r *http.Request) bool {
+	if s.config.BasicAuthUser == "" {
+		return false
+	}
+	user, pass, ok := r.BasicAuth()
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.config.BasicAuthUser))
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(s.config.BasicAuthPass))
+	return ok && userOK&passOK == 1
+}
+
+// authConfigured reports whether the API requires credentials, destructive
+// endpoints are refused otherwise
+func (s *Server) authConfigured() bool {
+	return s.config.APIToken != "" || s.config.BasicAuthUser != ""
+}
+
+// withCORS lets the configured origins call the API from a browser. Preflight
+// requests are answered here, before requireToken, since browsers send them
+// without credentials.
+func withCORS(origins []string, next http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return next
+	}
+	allowAll := slices.Contains(origins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		if !allowAll && !slices.Contains(origins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if allowAll {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			header.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			header.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
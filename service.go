@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+const (
+	systemdUnitPath = "/etc/systemd/system/digital-signage.service"
+	launchdLabel    = "com.digital-signage"
+)
+
+var systemdTemplate = template.Must(template.New("systemd").Funcs(template.FuncMap{
+	"quote":     systemdQuote,
+	"execQuote": systemdExecQuote,
+}).Parse(`[Unit]
+Description=Digital Signage Application
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+{{- if .User}}
+User={{.User}}
+{{- end}}
+WorkingDirectory={{.WorkingDir}}
+ExecStart={{execQuote .Executable}}{{if .ConfigFile}} --config {{execQuote .ConfigFile}}{{end}}
+Restart=on-failure
+RestartSec=10
+StandardOutput=journal
+StandardError=journal
+
+# Environment variables
+{{- range .Env}}
+Environment={{quote (print .Name "=" .Value)}}
+{{- end}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+var launchdTemplate = template.Must(template.New("launchd").Funcs(template.FuncMap{
+	"xml": template.HTMLEscapeString,
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>{{.Label}}</string>
+    <key>ProgramArguments</key>
+    <array>
+        <string>{{xml .Executable}}</string>
+{{- if .ConfigFile}}
+        <string>--config</string>
+        <string>{{xml .ConfigFile}}</string>
+{{- end}}
+    </array>
+    <key>WorkingDirectory</key>
+    <string>{{xml .WorkingDir}}</string>
+    <key>EnvironmentVariables</key>
+    <dict>
+{{- range .Env}}
+        <key>{{xml .Name}}</key>
+        <string>{{xml .Value}}</string>
+{{- end}}
+    </dict>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>KeepAlive</key>
+    <dict>
+        <key>SuccessfulExit</key>
+        <false/>
+    </dict>
+    <key>ThrottleInterval</key>
+    <integer>10</integer>
+</dict>
+</plist>
+`))
+
+// systemdQuote quotes a unit file value, escaping the characters systemd
+// would otherwise read as the end of the string, an escape or a specifier
+func systemdQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "\n", `\n`, "\r", `\r`).Replace(value) + `"`
+}
+
+// servicePathNames are the settings holding files or directories, written
+// as absolute paths
+var servicePathNames = map[string]bool{
+	"MEDIA_DIR": true, "MEDIA_DIRS": true, "SPLASH_MEDIA": true, "INDEX_TEMPLATE": true,
+	"HLS_JS": true, "TLS_CERT": true, "TLS_KEY": true,
+}
+
+// serviceCredentialNames are passed through only when they are explicitly
+// set, otherwise the SDKs fall back to their default credential chain
+var serviceCredentialNames = []string{
+	"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE",
+	"GOOGLE_APPLICATION_CREDENTIALS",
+}
+
+// systemdExecQuote quotes an ExecStart argument, which unlike other values
+// also expands $VARIABLE references
+func systemdExecQuote(value string) string {
+	return strings.ReplaceAll(systemdQuote(value), "$", "$$")
+}
+
+type serviceEnvVar struct {
+	Name  string
+	Value string
+}
+
+type serviceData struct {
+	Label      string
+	User       string
+	Executable string
+	ConfigFile string
+	WorkingDir string
+	Env        []serviceEnvVar
+}
+
+// runInstallService handles the install-service subcommand, which renders a
+// service definition for the current configuration to stdout or installs it.
+// fileKeys are the variables applyConfigFile set from configFile.
+func runInstallService(configFile string, fileKeys map[string]bool, args []string) error {
+	defaultFormat := "systemd"
+	if runtime.GOOS == "darwin" {
+		defaultFormat = "launchd"
+	}
+
+	fs := flag.NewFlagSet("install-service", flag.ContinueOnError)
+	format := fs.String("format", defaultFormat, "Service format: systemd or launchd")
+	install := fs.Bool("install", false, "Write the service file instead of printing it")
+	user := fs.String("user", os.Getenv("USER"), "User the systemd service runs as")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := newServiceData(configFile, fileKeys, *user)
+	if err != nil {
+		return err
+	}
+
+	var tmpl *template.Template
+	var target string
+	switch *format {
+	case "systemd":
+		tmpl = systemdTemplate
+		target = systemdUnitPath
+	case "launchd":
+		tmpl = launchdTemplate
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		target = filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist")
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	if !*install {
+		return tmpl.Execute(os.Stdout, data)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// The environment holds credentials, only the owner may read the file
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return err
+	}
+	if err := tmpl.Execute(file, data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("Service file written to %s", target)
+	if *format == "systemd" {
+		log.Println("Run: sudo systemctl daemon-reload && sudo systemctl enable --now digital-signage")
+	} else {
+		log.Printf("Run: launchctl load %s", target)
+	}
+	return nil
+}
+
+// newServiceData collects the settings the service runs with: every
+// recognised variable that is set, except those read from the --config file,
+// which the service reads itself so a SIGHUP still reloads them.
+func newServiceData(configFile string, fileKeys map[string]bool, user string) (serviceData, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return serviceData{}, err
+	}
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return serviceData{}, err
+	}
+	if configFile != "" {
+		if configFile, err = filepath.Abs(configFile); err != nil {
+			return serviceData{}, err
+		}
+	}
+
+	var env []serviceEnvVar
+	for _, name := range slices.Concat(settingNames, serviceCredentialNames) {
+		value := os.Getenv(name)
+		if value == "" || fileKeys[name] {
+			continue
+		}
+		if name == "MEDIA_DIRS" {
+			dirs := splitDirList(value)
+			for i, dir := range dirs {
+				if dirs[i], err = filepath.Abs(dir); err != nil {
+					return serviceData{}, err
+				}
+			}
+			value = strings.Join(dirs, ",")
+		} else if servicePathNames[name] {
+			if value, err = filepath.Abs(value); err != nil {
+				return serviceData{}, err
+			}
+		}
+		env = append(env, serviceEnvVar{name, value})
+	}
+
+	return serviceData{
+		Label:      launchdLabel,
+		User:       user,
+		Executable: executable,
+		ConfigFile: configFile,
+		WorkingDir: workingDir,
+		Env:        env,
+	}, nil
+}
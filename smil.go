@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// smilDocument is the minimal SMIL 3.0 playlist other signage players read,
+// the files play in sequence forever
+type smilDocument struct {
+	XMLName xml.Name `xml:"smil"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Seq     smilSeq  `xml:"body>seq"`
+}
+
+type smilSeq struct {
+	RepeatCount string      `xml:"repeatCount,attr"`
+	Items       []smilMedia `xml:"media"`
+}
+
+// smilMedia is a <video> or <img> element, the name is set per item
+type smilMedia struct {
+	XMLName xml.Name
+	Src     string `xml:"src,attr"`
+	Dur     string `xml:"dur,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+}
+
+// handlePlaylistSMIL renders the playlist /api/media returns as SMIL. Images
+// are shown for IMAGE_DURATION_SECONDS, videos play to the end unless
+// playlist.json limits them. URLs are absolute so players fetch the files
+// from this server.
+func (s *Server) handlePlaylistSMIL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := scheme + "://" + r.Host
+
+	doc := smilDocument{Xmlns: "http://www.w3.org/ns/SMIL", Seq: smilSeq{RepeatCount: "indefinite"}}
+	for _, media := range s.effectivePlaylist(r) {
+		item := smilMedia{XMLName: xml.Name{Local: "video"}, Src: base + media.URL}
+		switch {
+		case media.MaxDuration > 0:
+			item.Dur = strconv.Itoa(media.MaxDuration) + "s"
+		case media.Type == "image":
+			item.Dur = strconv.Itoa(int(s.config.ImageDuration.Seconds())) + "s"
+		case media.Duration != nil:
+			item.Dur = fmt.Sprintf("%.3fs", *media.Duration)
+		}
+		if media.Type == "image" {
+			item.XMLName.Local = "img"
+		}
+		if media.Type == "hls" {
+			item.Type = mediaContentTypes[".m3u8"]
+		}
+		doc.Seq.Items = append(doc.Seq.Items, item)
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/smil+xml")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const syncStateFileName = ".sync-state.json"
+
+// syncState is what the last syncs did. It is stored next to the manifest so
+// the status endpoint can report it right after a restart.
+type syncState struct {
+	LastSync            *SyncResult `json:"last_sync"`
+	LastAttempt         time.Time   `json:"last_attempt"`
+	LastError           string      `json:"last_error,omitempty"`
+	ConsecutiveFailures int         `json:"consecutive_failures"`
+	MediaCount          int         `json:"media_count"`
+}
+
+func loadSyncState(dir string) (syncState, error) {
+	var state syncState
+	data, err := os.ReadFile(filepath.Join(dir, syncStateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// saveSyncState writes the state to a temporary file and renames it into
+// place. Callers must hold stateMu.
+func (s *Server) saveSyncState() {
+	data, err := json.MarshalIndent(s.syncState, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode sync state: %v", err)
+		return
+	}
+	path := filepath.Join(s.config.MediaDir, syncStateFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		log.Printf("Failed to save sync state: %v", err)
+	}
+}
+
+// recordSyncError keeps a failed sync in the state
+func (s *Server) recordSyncError(err error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.syncState.LastAttempt = time.Now()
+	s.syncState.LastError = err.Error()
+	s.syncState.ConsecutiveFailures++
+	s.syncState.MediaCount = len(s.currentMedia())
+	s.saveSyncState()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const playStatsFileName = ".play-stats.json"
+
+// playStat is how often a file, by path, was reported on screen
+type playStat struct {
+	Plays      int       `json:"plays"`
+	LastPlayed time.Time `json:"last_played"`
+}
+
+// loadPlayStats reads the persisted play counts from dir, none if they
+// weren't saved yet
+func loadPlayStats(dir string) (map[string]playStat, error) {
+	stats := make(map[string]playStat)
+	data, err := os.ReadFile(filepath.Join(dir, playStatsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return make(map[string]playStat), err
+	}
+	return stats, nil
+}
+
+// playStatsSaveInterval is how often changed play counts are persisted,
+// the display reports every file it shows
+const playStatsSaveInterval = time.Minute
+
+// recordPlay counts a report from the display for the file at path. Files
+// that are gone are dropped, so a file added again later starts over. The
+// counts are persisted by playStatsLoop. Callers must hold stateMu.
+func (s *Server) recordPlay(path string, t time.Time) {
+	stat := s.playCounts[path]
+	stat.Plays++
+	stat.LastPlayed = t
+	s.playCounts[path] = stat
+
+	current := make(map[string]bool)
+	for _, media := range s.currentMedia() {
+		current[media.Path] = true
+	}
+	for path := range s.playCounts {
+		if !current[path] {
+			delete(s.playCounts, path)
+		}
+	}
+	s.statsDirty = true
+}
+
+// playStatsLoop saves the play counts every playStatsSaveInterval while
+// they changed, until ctx is done
+func (s *Server) playStatsLoop(ctx context.Context) {
+	ticker := time.NewTicker(playStatsSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.savePlayStats()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// savePlayStats persists the play counts if they changed since the last save
+func (s *Server) savePlayStats() {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if !s.statsDirty {
+		return
+	}
+
+	data, err := json.MarshalIndent(s.playCounts, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode play stats: %v", err)
+		return
+	}
+	file := filepath.Join(s.config.MediaDir, playStatsFileName)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		log.Printf("Failed to save play stats: %v", err)
+		return
+	}
+	s.statsDirty = false
+}
+
+// handleStatsAPI returns how often each current media file was played and
+// when it was last on screen
+func (s *Server) handleStatsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	files := []map[string]interface{}{}
+	total := 0
+	for _, media := range s.currentMedia() {
+		stat := s.playCounts[media.Path]
+		entry := map[string]interface{}{
+			"name":  media.Name,
+			"url":   media.URL,
+			"plays": stat.Plays,
+		}
+		if !stat.LastPlayed.IsZero() {
+			entry["last_played"] = stat.LastPlayed.Format(time.RFC3339)
+		}
+		files = append(files, entry)
+		total += stat.Plays
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files":       files,
+		"total_plays": total,
+	})
+}
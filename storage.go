@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// RemoteObject describes a file in the remote storage backend
+type RemoteObject struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// errNotFound is wrapped by backends around errors for a missing bucket or
+// object, which are permanent and not worth retrying
+var errNotFound = errors.New("not found")
+
+// errCannotResume is returned by Download for a partial download that can't
+// be continued, the object was replaced or the range not honored. The
+// download starts over from the beginning then.
+var errCannotResume = errors.New("cannot resume download")
+
+// MediaSource is a remote storage backend media is synced from
+type MediaSource interface {
+	// List returns every object whose key starts with prefix. It either
+	// returns the complete listing or an error, never a partial result.
+	List(ctx context.Context, prefix string) ([]RemoteObject, error)
+	// Download writes the content of the object to dest, from offset on.
+	// A non-zero offset continues an earlier download of the same version.
+	Download(ctx context.Context, obj RemoteObject, offset int64, dest io.Writer) (int64, error)
+	// String identifies the source in logs, e.g. s3://bucket
+	String() string
+}
+
+// newMediaSource creates the backend selected by STORAGE_BACKEND, or returns
+// nil if no bucket is configured for it
+func newMediaSource(ctx context.Context, cfg AppConfig) (MediaSource, error) {
+	switch cfg.StorageBackend {
+	case "s3":
+		if cfg.S3Bucket == "" {
+			return nil, nil
+		}
+		return newS3Source(ctx, cfg)
+	case "gcs":
+		if cfg.GCSBucket == "" {
+			return nil, nil
+		}
+		return newGCSSource(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q, expected s3 or gcs", cfg.StorageBackend)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// gcsSource syncs media from a Google Cloud Storage bucket. Credentials come
+// from Application Default Credentials, e.g. GOOGLE_APPLICATION_CREDENTIALS.
+type gcsSource struct {
+	client *storage.Client
+	bucket string
+}
+
+func newGCSSource(ctx context.Context, cfg AppConfig) (*gcsSource, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsSource{
+		client: client,
+		bucket: cfg.GCSBucket,
+	}, nil
+}
+
+func (src *gcsSource) String() string {
+	return "gs://" + src.bucket
+}
+
+func (src *gcsSource) List(ctx context.Context, prefix string) ([]RemoteObject, error) {
+	var objects []RemoteObject
+	it := src.client.Bucket(src.bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, gcsError(err)
+		}
+
+		// Composite objects have no MD5, leaving the ETag empty makes the
+		// sync compare size and modification time instead
+		var etag string
+		if len(attrs.MD5) > 0 {
+			etag = hex.EncodeToString(attrs.MD5)
+		}
+		objects = append(objects, RemoteObject{
+			Key:          attrs.Name,
+			Size:         attrs.Size,
+			ETag:         etag,
+			LastModified: attrs.Updated,
+		})
+	}
+	return objects, nil
+}
+
+func (src *gcsSource) Download(ctx context.Context, obj RemoteObject, offset int64, dest io.Writer) (int64, error) {
+	handle := src.client.Bucket(src.bucket).Object(obj.Key)
+	// Continue only from the generation whose MD5 was listed
+	if offset > 0 {
+		attrs, err := handle.Attrs(ctx)
+		if err != nil {
+			return 0, gcsError(err)
+		}
+		if hex.EncodeToString(attrs.MD5) != obj.ETag {
+			return 0, errCannotResume
+		}
+		handle = handle.If(storage.Conditions{GenerationMatch: attrs.Generation})
+	}
+	reader, err := handle.NewRangeReader(ctx, offset, -1)
+	if err != nil {
+		return 0, gcsError(err)
+	}
+	defer reader.Close()
+
+	return io.Copy(dest, reader)
+}
+
+// gcsError marks missing buckets and objects as errNotFound
+func gcsError(err error) error {
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		return fmt.Errorf("%w: %w", errNotFound, err)
+	}
+	return err
+}
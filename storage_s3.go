@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// expiredCredentialCodes are the S3 error codes returned when the signing
+// credentials are no longer valid, e.g. after a role was rotated
+var expiredCredentialCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+	"InvalidAccessKeyId":    true,
+	"InvalidToken":          true,
+	"RequestExpired":        true,
+	"SignatureDoesNotMatch": true,
+}
+
+// s3Source syncs media from an S3 bucket
+type s3Source struct {
+	bucket string
+	region string
+	// endpoint and pathStyle point the client at S3-compatible storage
+	// such as MinIO
+	endpoint  string
+	pathStyle bool
+	// kmsKey, when set, is the KMS key every object must be encrypted
+	// with. S3 decrypts SSE-KMS objects itself for callers allowed to use
+	// the key, so it is only checked.
+	kmsKey string
+
+	mu     sync.Mutex
+	client *s3.Client
+}
+
+func newS3Source(ctx context.Context, cfg AppConfig) (*s3Source, error) {
+	src := &s3Source{
+		bucket:    cfg.S3Bucket,
+		region:    cfg.S3Region,
+		endpoint:  cfg.S3Endpoint,
+		pathStyle: cfg.S3PathStyle,
+		kmsKey:    cfg.S3KMSKey,
+	}
+	if err := src.connect(ctx); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// connect (re)creates the client from the default credential chain
+func (src *s3Source) connect(ctx context.Context) error {
+	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(src.region))
+	if err != nil {
+		return err
+	}
+	src.mu.Lock()
+	src.client = s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		if src.endpoint != "" {
+			o.BaseEndpoint = aws.String(src.endpoint)
+		}
+		o.UsePathStyle = src.pathStyle
+	})
+	src.mu.Unlock()
+	return nil
+}
+
+func (src *s3Source) currentClient() *s3.Client {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	return src.client
+}
+
+// withFreshCredentials runs fn and, if it failed because the credentials
+// expired, reloads them and runs fn once more
+func (src *s3Source) withFreshCredentials(ctx context.Context, fn func(client *s3.Client) error) error {
+	err := fn(src.currentClient())
+	var apiErr smithy.APIError
+	if err == nil || !errors.As(err, &apiErr) || !expiredCredentialCodes[apiErr.ErrorCode()] {
+		return err
+	}
+
+	log.Printf("S3 credentials rejected (%s), reloading them", apiErr.ErrorCode())
+	if refreshErr := src.connect(ctx); refreshErr != nil {
+		log.Printf("Failed to reload S3 credentials: %v", refreshErr)
+		return err
+	}
+	return fn(src.currentClient())
+}
+
+func (src *s3Source) String() string {
+	return "s3://" + src.bucket
+}
+
+// List follows continuation tokens so buckets with more than 1000 objects
+// are listed completely
+func (src *s3Source) List(ctx context.Context, prefix string) ([]RemoteObject, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(src.bucket),
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+
+	var objects []RemoteObject
+	err := src.withFreshCredentials(ctx, func(client *s3.Client) error {
+		var err error
+		objects, err = listObjects(ctx, client, input)
+		return err
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return objects, nil
+}
+
+// listObjects collects every page of the listing, or returns an error and no
+// objects if any page fails
+func listObjects(ctx context.Context, client s3.ListObjectsV2APIClient, input *s3.ListObjectsV2Input) ([]RemoteObject, error) {
+	var objects []RemoteObject
+	paginator := s3.NewListObjectsV2Paginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			if obj.Key == nil {
+				continue
+			}
+			objects = append(objects, RemoteObject{
+				Key:          *obj.Key,
+				Size:         obj.Size,
+				ETag:         aws.ToString(obj.ETag),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (src *s3Source) Download(ctx context.Context, obj RemoteObject, offset int64, dest io.Writer) (int64, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(src.bucket),
+		Key:    aws.String(obj.Key),
+	}
+	// The ETag makes sure the rest comes from the same version
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+		input.IfMatch = aws.String(obj.ETag)
+	}
+	var resp *s3.GetObjectOutput
+	err := src.withFreshCredentials(ctx, func(client *s3.Client) error {
+		var err error
+		resp, err = client.GetObject(ctx, input)
+		return err
+	})
+	var respErr *awshttp.ResponseError
+	if offset > 0 && errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusPreconditionFailed {
+		return 0, fmt.Errorf("%w: %w", errCannotResume, err)
+	}
+	if err != nil {
+		return 0, s3Error(err)
+	}
+	defer resp.Body.Close()
+
+	if offset > 0 && !strings.HasPrefix(aws.ToString(resp.ContentRange), fmt.Sprintf("bytes %d-", offset)) {
+		return 0, fmt.Errorf("%w: range not honored", errCannotResume)
+	}
+	if err := src.checkEncryption(resp); err != nil {
+		return 0, err
+	}
+	return io.Copy(dest, resp.Body)
+}
+
+// checkEncryption rejects objects that aren't encrypted with the configured
+// KMS key. S3 reports the key ARN, a key ID matches its last part; an alias
+// can't be resolved without KMS access, so only SSE-KMS is required then.
+func (src *s3Source) checkEncryption(resp *s3.GetObjectOutput) error {
+	if src.kmsKey == "" {
+		return nil
+	}
+	if resp.ServerSideEncryption != types.ServerSideEncryptionAwsKms && resp.ServerSideEncryption != types.ServerSideEncryptionAwsKmsDsse {
+		return fmt.Errorf("object is not encrypted with SSE-KMS (server-side encryption: %q)", resp.ServerSideEncryption)
+	}
+	keyID := aws.ToString(resp.SSEKMSKeyId)
+	if strings.HasPrefix(src.kmsKey, "alias/") || strings.Contains(src.kmsKey, ":alias/") {
+		return nil
+	}
+	if keyID != src.kmsKey && !strings.HasSuffix(keyID, ":key/"+src.kmsKey) {
+		return fmt.Errorf("object is encrypted with KMS key %s, expected %s", keyID, src.kmsKey)
+	}
+	return nil
+}
+
+// s3Error marks 404 responses as errNotFound
+func s3Error(err error) error {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", errNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// pagedLister serves a listing in pages of up to 1000 objects, failing the
+// page at failPage if set
+type pagedLister struct {
+	keys     []string
+	failPage int
+	calls    int
+}
+
+func (l *pagedLister) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	l.calls++
+	if l.calls == l.failPage {
+		return nil, errors.New("connection reset")
+	}
+	start := 0
+	if input.ContinuationToken != nil {
+		start, _ = strconv.Atoi(*input.ContinuationToken)
+	}
+	end := min(start+1000, len(l.keys))
+
+	output := &s3.ListObjectsV2Output{IsTruncated: end < len(l.keys)}
+	for _, key := range l.keys[start:end] {
+		output.Contents = append(output.Contents, types.Object{Key: aws.String(key), Size: 1})
+	}
+	if end < len(l.keys) {
+		output.NextContinuationToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
+
+func manyKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("clip%04d.mp4", i)
+	}
+	return keys
+}
+
+func TestListObjectsPaginates(t *testing.T) {
+	lister := &pagedLister{keys: manyKeys(1001)}
+	objects, err := listObjects(context.Background(), lister, &s3.ListObjectsV2Input{Bucket: aws.String("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lister.calls != 2 {
+		t.Errorf("listed %d pages, want 2", lister.calls)
+	}
+	if len(objects) != 1001 {
+		t.Fatalf("got %d objects, want 1001", len(objects))
+	}
+	for i, obj := range objects {
+		if obj.Key != lister.keys[i] {
+			t.Fatalf("object %d is %q, want %q", i, obj.Key, lister.keys[i])
+		}
+	}
+}
+
+func TestListObjectsFailedPage(t *testing.T) {
+	lister := &pagedLister{keys: manyKeys(1001), failPage: 2}
+	objects, err := listObjects(context.Background(), lister, &s3.ListObjectsV2Input{Bucket: aws.String("b")})
+	if err == nil {
+		t.Fatal("expected an error for the failed second page")
+	}
+	if objects != nil {
+		t.Errorf("got %d objects from a partial listing, want none", len(objects))
+	}
+}
@@ -0,0 +1,694 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func (s *Server) syncLoop(ctx context.Context) {
+	log.Printf("Starting sync loop for %s", s.source)
+
+	// Initial sync. The device may boot without network, keep retrying
+	// more often than the sync interval until storage is reachable; local media
+	// keeps playing in the meantime.
+	retryDelay := time.Minute
+	for {
+		if _, err := s.syncMedia(ctx); err == nil {
+			break
+		}
+		delay := min(retryDelay, s.syncInterval())
+		log.Printf("Retrying sync in %v, playing local media meanwhile", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		retryDelay *= 2
+	}
+
+	// Periodic sync
+	ticker := time.NewTicker(s.syncInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.syncMedia(ctx)
+		case <-s.reloaded:
+			ticker.Reset(s.syncInterval())
+		case <-ctx.Done():
+			log.Println("Sync loop stopped")
+			return
+		}
+	}
+}
+
+// syncInterval is the time between syncs, SIGHUP may change it
+func (s *Server) syncInterval() time.Duration {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.syncEvery
+}
+
+// SyncResult lists the files, relative to the sync target, that a sync
+// added, replaced or removed, and the files it failed to handle
+type SyncResult struct {
+	Time    time.Time `json:"time"`
+	Added   []string  `json:"added"`
+	Updated []string  `json:"updated"`
+	Deleted []string  `json:"deleted"`
+	Errors  []string  `json:"errors,omitempty"`
+	// DryRun is set when the changes were only planned, not made
+	DryRun bool `json:"dry_run,omitempty"`
+	// Bytes is how much was downloaded, failed downloads included, in
+	// Duration seconds
+	Bytes      int64   `json:"bytes"`
+	Duration   float64 `json:"duration_seconds"`
+	Throughput float64 `json:"bytes_per_second"`
+}
+
+// Changed reports whether the sync touched any local file
+func (r SyncResult) Changed() bool {
+	return len(r.Added) > 0 || len(r.Updated) > 0 || len(r.Deleted) > 0
+}
+
+// syncMedia runs a sync, waiting for any sync already in progress
+func (s *Server) syncMedia(ctx context.Context) (SyncResult, error) {
+	if s.source == nil {
+		return SyncResult{}, nil
+	}
+
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	result, err := s.reconcile(ctx, s.config.SyncDryRun)
+	if err != nil {
+		slog.Error("Sync failed", "event", "sync_error", "error", err)
+		if ctx.Err() == nil {
+			s.recordSyncError(err) // Shutting down is not a failure
+		}
+	}
+	return result, err
+}
+
+// reconcile makes the sync target match the remote source, independently
+// of the storage backend. With dryRun it only reports what it would change.
+// Callers must hold syncMu.
+func (s *Server) reconcile(ctx context.Context, dryRun bool) (SyncResult, error) {
+	result := SyncResult{
+		Time:    time.Now(),
+		Added:   []string{},
+		Updated: []string{},
+		Deleted: []string{},
+	}
+
+	started := time.Now()
+	prefixes := s.syncPrefixes()
+	slog.Info("Starting sync", "event", "sync_start", "source", s.source.String(), "prefix", strings.Join(prefixes, ","))
+
+	// Each prefix is reconciled on its own, a prefix that can't be listed
+	// keeps its local files untouched while the others still sync
+	mediaList := s.currentMedia()
+	var downloads []downloadJob
+	var localFilesToRemove []string
+	manifestDirty := false
+	listed := make(map[string]bool)
+	var listErr error
+	for _, prefix := range prefixes {
+		var objects []RemoteObject
+		err := withRetry(ctx, s.config.S3MaxRetries, "list", func() error {
+			var err error
+			objects, err = s.source.List(ctx, prefix)
+			return err
+		})
+		if err != nil {
+			syncErrorsTotal.Inc()
+			if ctx.Err() != nil || len(prefixes) == 1 {
+				return result, fmt.Errorf("failed to list objects: %w", err)
+			}
+			slog.Error("Failed to list prefix", "event", "list_error", "prefix", prefix, "error", err)
+			result.Errors = append(result.Errors, "list "+prefix+": "+err.Error())
+			listErr = err
+			continue
+		}
+
+		for _, obj := range objects {
+			listed[filepath.Join(s.config.MediaDir, s.localName(obj.Key, prefix))] = true
+		}
+		jobs, orphans, dirty := s.comparePrefix(prefix, objects, mediaList, dryRun)
+		downloads = append(downloads, jobs...)
+		localFilesToRemove = append(localFilesToRemove, orphans...)
+		manifestDirty = manifestDirty || dirty
+	}
+	if listErr != nil && len(result.Errors) == len(prefixes) {
+		// Couldn't reach storage, leave local files untouched
+		return result, fmt.Errorf("failed to list objects: %w", listErr)
+	}
+
+	localFilesToRemove = s.syncedOrphans(localFilesToRemove)
+	downloads = s.skipOversized(downloads)
+	downloads = s.skipQuarantined(downloads, time.Now())
+	sortDownloads(downloads, s.config.DownloadOrder == "newest")
+	if dryRun {
+		return s.planSync(downloads, localFilesToRemove, result), nil
+	}
+
+	downloads = s.makeRoom(downloads, &result)
+	if s.downloadAll(ctx, downloads, &result) > 0 {
+		manifestDirty = true
+	}
+	if err := ctx.Err(); err != nil {
+		// Interrupted, don't remove orphans based on an unfinished pass
+		if manifestDirty {
+			s.manifest.save()
+		}
+		return result, err
+	}
+
+	if len(localFilesToRemove) > 0 {
+		slog.Info("Files were deleted remotely and need to be deleted from local storage", "event", "orphans", "count", len(localFilesToRemove))
+		for _, localF := range localFilesToRemove {
+			relPath, _ := filepath.Rel(s.config.MediaDir, localF)
+			key := filepath.ToSlash(relPath)
+			if err := os.Remove(localF); err != nil {
+				slog.Error("Failed to delete", "event", "delete_error", "key", key, "error", err)
+				result.Errors = append(result.Errors, err.Error())
+				continue
+			}
+			slog.Info("Deleted", "event", "delete", "key", key)
+			pruneEmptyDirs(filepath.Dir(localF), s.config.MediaDir)
+			result.Deleted = append(result.Deleted, key)
+			s3DeletesTotal.Inc()
+			delete(s.manifest.Objects, key)
+			manifestDirty = true
+		}
+	}
+
+	// Partials under a prefix that couldn't be listed may still be current
+	if listErr == nil {
+		s.removeOrphanPartials(listed)
+	}
+
+	if manifestDirty {
+		if err := s.manifest.save(); err != nil {
+			slog.Error("Failed to save sync manifest", "event", "manifest_error", "error", err)
+		}
+	}
+
+	elapsed := time.Since(started)
+	result.Duration = elapsed.Seconds()
+	if result.Bytes > 0 && elapsed > 0 {
+		result.Throughput = float64(result.Bytes) / elapsed.Seconds()
+	}
+	lastSyncSuccessGauge.SetToCurrentTime()
+	lastSyncDurationGauge.Set(result.Duration)
+	lastSyncBytesGauge.Set(float64(result.Bytes))
+	slog.Info("Sync completed", "event", "sync_complete",
+		"added", len(result.Added), "updated", len(result.Updated), "deleted", len(result.Deleted),
+		"bytes", result.Bytes, "duration_ms", elapsed.Milliseconds(), "bytes_per_second", int64(result.Throughput))
+	if result.Changed() {
+		s.scanMedia() // Refresh media list
+	}
+	s.recordSync(result)
+	return result, nil
+}
+
+// syncPrefixes returns the prefixes to sync, the whole bucket if none is
+// configured
+func (s *Server) syncPrefixes() []string {
+	if len(s.config.S3Prefixes) == 0 {
+		return []string{""}
+	}
+	return s.config.S3Prefixes
+}
+
+// localName maps a key to its path relative to MediaDir. A single prefix is
+// stripped, with several each prefix stays a subfolder so they can't collide.
+func (s *Server) localName(key, prefix string) string {
+	if len(s.config.S3Prefixes) > 1 {
+		return key
+	}
+	return strings.TrimPrefix(key, prefix)
+}
+
+// comparePrefix compares the objects listed under prefix with the local
+// files, returning the objects to download and the files under the prefix
+// that are missing remotely. It reports whether the manifest was updated.
+func (s *Server) comparePrefix(prefix string, objects []RemoteObject, mediaList []MediaFile, dryRun bool) ([]downloadJob, []string, bool) {
+	// Only files in the sync target are candidates for removal, the other
+	// media directories are never managed by the sync.
+	localFilesToRemove := make([]string, 0, len(mediaList))
+	for _, media := range mediaList {
+		if media.root == 0 && (len(s.config.S3Prefixes) <= 1 || media.Source == strings.TrimSuffix(prefix, "/")) {
+			localFilesToRemove = append(localFilesToRemove, media.Path)
+		}
+	}
+	var downloads []downloadJob
+	manifestDirty := false
+	for _, obj := range resolveCaseCollisions(objects, s.config.CaseCollision == "last") {
+		// Keys under the prefix land relative to MediaDir
+		fileName := s.localName(obj.Key, prefix)
+		if fileName == "" || strings.HasSuffix(fileName, "/") {
+			continue // folder placeholder
+		}
+		localPath := filepath.Join(s.config.MediaDir, fileName)
+
+		// Check if file exists
+		info, err := os.Stat(localPath)
+		exists := err == nil
+		if exists {
+			// Delete from known localfiles. On a case-insensitive filesystem
+			// the existing file may be spelled differently than the key.
+			index := slices.Index(localFilesToRemove, localPath)
+			if index == -1 {
+				index = slices.IndexFunc(localFilesToRemove, func(p string) bool {
+					return strings.EqualFold(p, localPath)
+				})
+			}
+			if index != -1 {
+				localFilesToRemove = slices.Delete(localFilesToRemove, index, index+1)
+			}
+
+			// Skip files whose content hasn't changed remotely
+			if !s.manifest.changed(fileName, obj, info.Size(), s.config.SyncCompare == "modified") {
+				if _, ok := s.manifest.Objects[fileName]; !ok && !dryRun {
+					s.manifest.record(fileName, obj)
+					manifestDirty = true
+				}
+				continue
+			}
+		}
+
+		downloads = append(downloads, downloadJob{obj: obj, name: fileName, localPath: localPath, exists: exists})
+	}
+	return downloads, localFilesToRemove, manifestDirty
+}
+
+// syncedOrphans narrows files missing remotely down to the ones the sync
+// downloaded itself, so files placed in MediaDir by other means survive.
+// SYNC_DELETE_ORPHANS removes every file missing remotely instead.
+func (s *Server) syncedOrphans(orphans []string) []string {
+	if s.config.DeleteOrphans {
+		return orphans
+	}
+	synced := orphans[:0]
+	for _, localF := range orphans {
+		relPath, _ := filepath.Rel(s.config.MediaDir, localF)
+		if _, ok := s.manifest.Objects[filepath.ToSlash(relPath)]; ok {
+			synced = append(synced, localF)
+		}
+	}
+	return synced
+}
+
+// planSync fills the result with the changes a sync would make, logging
+// each of them, without touching any file
+func (s *Server) planSync(jobs []downloadJob, orphans []string, result SyncResult) SyncResult {
+	result.DryRun = true
+	for _, job := range jobs {
+		if job.exists {
+			slog.Info("Would update", "event", "dry_run_update", "key", job.name, "bytes", job.obj.Size)
+			result.Updated = append(result.Updated, job.name)
+		} else {
+			slog.Info("Would download", "event", "dry_run_download", "key", job.name, "bytes", job.obj.Size)
+			result.Added = append(result.Added, job.name)
+		}
+	}
+	for _, localF := range orphans {
+		relPath, _ := filepath.Rel(s.config.MediaDir, localF)
+		key := filepath.ToSlash(relPath)
+		slog.Info("Would delete", "event", "dry_run_delete", "key", key)
+		result.Deleted = append(result.Deleted, key)
+	}
+	sort.Strings(result.Added)
+	sort.Strings(result.Updated)
+
+	slog.Info("Dry run completed", "event", "dry_run_complete",
+		"added", len(result.Added), "updated", len(result.Updated), "deleted", len(result.Deleted))
+	return result
+}
+
+// downloadJob is an object that is missing locally or changed remotely
+type downloadJob struct {
+	obj       RemoteObject
+	name      string
+	localPath string
+	exists    bool
+}
+
+// downloadAll fetches the jobs with at most S3Concurrency downloads in
+// flight, recording each success in the manifest and the result. It returns
+// the number of files downloaded.
+func (s *Server) downloadAll(ctx context.Context, jobs []downloadJob, result *SyncResult) int {
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		downloaded int
+	)
+	sem := make(chan struct{}, max(s.config.S3Concurrency, 1))
+
+	for _, job := range jobs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(job downloadJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// Each download still goes through a temp file and rename
+			started := time.Now()
+			bytes, err := s.downloadObject(ctx, job.obj, job.localPath)
+			if ctx.Err() == nil {
+				s.recordDownload(job, err)
+			}
+			syncBytesTotal.Add(float64(bytes))
+			mu.Lock()
+			result.Bytes += bytes
+			mu.Unlock()
+			if err != nil {
+				slog.Error("Failed to download", "event", "download_error", "key", job.name, "error", err)
+				syncErrorsTotal.Inc()
+				mu.Lock()
+				result.Errors = append(result.Errors, job.name+": "+err.Error())
+				mu.Unlock()
+				return
+			}
+			s3DownloadsTotal.Inc()
+			event := "download"
+			if job.exists {
+				event = "update"
+			}
+			slog.Info("Downloaded", "event", event, "key", job.name, "bytes", bytes,
+				"duration_ms", time.Since(started).Milliseconds())
+
+			mu.Lock()
+			defer mu.Unlock()
+			downloaded++
+			s.manifest.record(job.name, job.obj)
+			if job.exists {
+				result.Updated = append(result.Updated, job.name)
+			} else {
+				result.Added = append(result.Added, job.name)
+			}
+		}(job)
+	}
+	wg.Wait()
+
+	// Completion order depends on timing, keep the report stable
+	sort.Strings(result.Added)
+	sort.Strings(result.Updated)
+	sort.Strings(result.Errors)
+	return downloaded
+}
+
+// recordSync keeps the result for the status endpoint and reports the
+// changes in the log and to the webhook, if any
+func (s *Server) recordSync(result SyncResult) {
+	s.stateMu.Lock()
+	s.syncState.LastSync = &result
+	s.syncState.LastAttempt = result.Time
+	s.syncState.LastError = ""
+	s.syncState.ConsecutiveFailures = 0
+	s.syncState.MediaCount = len(s.currentMedia())
+	s.saveSyncState()
+	s.stateMu.Unlock()
+
+	if !result.Changed() {
+		return
+	}
+	slog.Info("Sync changes", "event", "sync_changes",
+		"added", result.Added, "updated", result.Updated, "deleted", result.Deleted)
+	if s.config.WebhookURL != "" {
+		go s.notifyWebhook(result)
+	}
+}
+
+// stateFileNames are the server's own files in MediaDir, they are kept
+// when the media is purged
+var stateFileNames = map[string]bool{
+	tickerFileName:        true,
+	syncStateFileName:     true,
+	playStatsFileName:     true,
+	playlistOrderFileName: true,
+}
+
+// purgeMedia removes everything inside the sync target directory, leaving
+// the directory itself and the server's own state files in place. Callers
+// must hold syncMu.
+func (s *Server) purgeMedia() (int, error) {
+	root, err := filepath.Abs(s.config.MediaDir)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if stateFileNames[entry.Name()] {
+			continue
+		}
+		path := filepath.Join(root, entry.Name())
+		// Entries come from ReadDir so this only guards against odd names;
+		// RemoveAll does not follow symlinks, so linked content is kept.
+		if filepath.Dir(path) != root {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return removed, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		removed++
+	}
+
+	s.manifest.Objects = make(map[string]objectRecord)
+
+	log.Printf("Purged %d entries from %s", removed, root)
+	s.scanMedia()
+	return removed, nil
+}
+
+// pruneEmptyDirs removes dir and its parents as long as they are empty,
+// stopping at root, which is always kept
+func pruneEmptyDirs(dir, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		// Remove refuses non-empty directories, which ends the walk
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		slog.Info("Removed empty directory", "event", "prune_dir", "dir", dir)
+	}
+}
+
+// sortDownloads puts the jobs in the order they are fetched, and kept by
+// makeRoom, by key or with the most recently modified object first. Several
+// prefixes are merged, so the listing order wouldn't mean much either way.
+func sortDownloads(jobs []downloadJob, newestFirst bool) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		a, b := jobs[i].obj, jobs[j].obj
+		if newestFirst && !a.LastModified.Equal(b.LastModified) {
+			return a.LastModified.After(b.LastModified)
+		}
+		return a.Key < b.Key
+	})
+}
+
+// partialPath is the temporary file an object is downloaded to. It is named
+// after the ETag, so a partial download is only continued for the version it
+// was started for.
+func partialPath(localPath, etag string) string {
+	h := fnv.New32a()
+	h.Write([]byte(etag))
+	return fmt.Sprintf("%s.%08x.tmp", localPath, h.Sum32())
+}
+
+// removeStalePartials deletes the partial downloads of localPath other than
+// keep, they were started for versions that have since been replaced
+func removeStalePartials(localPath, keep string) {
+	entries, err := os.ReadDir(filepath.Dir(localPath))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(filepath.Dir(localPath), entry.Name())
+		if target, ok := partialTarget(path); ok && target == localPath && path != keep {
+			os.Remove(path)
+		}
+	}
+}
+
+// partialTarget returns the file a partial download at path is for, if it
+// is one
+func partialTarget(path string) (string, bool) {
+	base, ok := strings.CutSuffix(path, ".tmp")
+	if !ok || len(base) < len(".00000000") {
+		return "", false
+	}
+	version := base[len(base)-len(".00000000"):]
+	if _, err := strconv.ParseUint(version[1:], 16, 32); err != nil || version[0] != '.' {
+		return "", false
+	}
+	return base[:len(base)-len(version)], true
+}
+
+// removeOrphanPartials deletes the partial downloads in the sync target whose
+// file is not in listed, their objects were deleted remotely
+func (s *Server) removeOrphanPartials(listed map[string]bool) {
+	filepath.WalkDir(s.config.MediaDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if target, ok := partialTarget(path); ok && !listed[target] {
+			if err := os.Remove(path); err == nil {
+				slog.Info("Deleted partial download", "event", "delete_partial", "path", path)
+			}
+		}
+		return nil
+	})
+}
+
+// restartFile empties a partial download, returning the new offset
+func restartFile(file *os.File) (int64, error) {
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	return file.Seek(0, io.SeekStart)
+}
+
+// checkSize fails a download that doesn't have the listed size. A longer
+// file can't be resumed and is removed, a shorter one is continued.
+func checkSize(path string, size int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > size {
+		os.Remove(path)
+	}
+	if info.Size() != size {
+		return fmt.Errorf("downloaded %d of %d bytes", info.Size(), size)
+	}
+	return nil
+}
+
+// resolveCaseCollisions drops objects whose keys differ only by case, so
+// they can't overwrite each other on a case-insensitive filesystem. Keys are
+// compared in sorted order and the first (or last, if keepLast) one wins,
+// which keeps the choice stable between syncs regardless of listing order.
+func resolveCaseCollisions(objects []RemoteObject, keepLast bool) []RemoteObject {
+	sorted := slices.Clone(objects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	if keepLast {
+		slices.Reverse(sorted)
+	}
+
+	winners := make(map[string]string)
+	result := make([]RemoteObject, 0, len(sorted))
+	for _, obj := range sorted {
+		normalized := strings.ToLower(obj.Key)
+		if winner, ok := winners[normalized]; ok {
+			slog.Warn("Key collides with another on case-insensitive filesystems",
+				"event", "case_collision", "key", obj.Key, "kept", winner)
+			continue
+		}
+		winners[normalized] = obj.Key
+		result = append(result, obj)
+	}
+	return result
+}
+
+// downloadObject fetches a remote object to localPath
+func (s *Server) downloadObject(ctx context.Context, obj RemoteObject, localPath string) (int64, error) {
+	key := obj.Key
+	// Create directory if needed
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return 0, err
+	}
+
+	// Download into a temporary file so the player never sees a partial
+	// download under the real name
+	tmpPath := partialPath(localPath, obj.ETag)
+	removeStalePartials(localPath, tmpPath)
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	// Each attempt continues where the last one, of this sync or an earlier
+	// one, stopped. Without an ETag there is no telling whether the partial
+	// file is of the same version, so those start over.
+	var written int64
+	err = withRetry(ctx, s.config.S3MaxRetries, "download "+key, func() error {
+		offset, err := file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if offset > 0 && (obj.ETag == "" || offset >= obj.Size) {
+			if offset, err = restartFile(file); err != nil {
+				return err
+			}
+		}
+		if offset > 0 {
+			slog.Info("Resuming download", "event", "download_resume", "key", key, "offset", offset)
+		}
+		n, err := s.source.Download(ctx, obj, offset, file)
+		written += n
+		if errors.Is(err, errCannotResume) {
+			slog.Info("Restarting download", "event", "download_restart", "key", key, "error", err)
+			if _, err := restartFile(file); err != nil {
+				return err
+			}
+			n, err = s.source.Download(ctx, obj, 0, file)
+			written += n
+		}
+		return err
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = checkSize(tmpPath, obj.Size)
+	}
+	if err != nil {
+		// Keep the partial file for the next sync, unless the object is gone
+		if errors.Is(err, errNotFound) {
+			os.Remove(tmpPath)
+		}
+		return written, err
+	}
+
+	// Keep whatever was there before rather than a corrupt download
+	if err := validateMedia(tmpPath, key, s.config.ValidateMedia); err != nil {
+		os.Remove(tmpPath)
+		return written, fmt.Errorf("invalid media file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		os.Remove(tmpPath)
+		return written, err
+	}
+	return written, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// captureLog sends slog output to a buffer for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func keysOf(objects []RemoteObject) []string {
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = obj.Key
+	}
+	return keys
+}
+
+func TestResolveCaseCollisions(t *testing.T) {
+	listings := [][]RemoteObject{
+		{{Key: "Promo.mp4", ETag: "a"}, {Key: "promo.mp4", ETag: "b"}, {Key: "other.mp4"}},
+		{{Key: "other.mp4"}, {Key: "promo.mp4", ETag: "b"}, {Key: "Promo.mp4", ETag: "a"}},
+	}
+	tests := []struct {
+		keepLast bool
+		winner   string
+		loser    string
+	}{
+		{false, "Promo.mp4", "promo.mp4"},
+		{true, "promo.mp4", "Promo.mp4"},
+	}
+
+	for _, tt := range tests {
+		for _, objects := range listings {
+			logs := captureLog(t)
+			kept := keysOf(resolveCaseCollisions(objects, tt.keepLast))
+
+			slices.Sort(kept)
+			want := []string{tt.winner, "other.mp4"}
+			slices.Sort(want)
+			if !slices.Equal(kept, want) {
+				t.Errorf("keepLast=%v, listing %v: kept %v, want %v", tt.keepLast, keysOf(objects), kept, want)
+			}
+			if line := logs.String(); !strings.Contains(line, "event=case_collision") ||
+				!strings.Contains(line, "key="+tt.loser) || !strings.Contains(line, "kept="+tt.winner) {
+				t.Errorf("keepLast=%v: collision not logged as expected: %q", tt.keepLast, line)
+			}
+		}
+	}
+}
+
+func TestResolveCaseCollisionsWithoutCollisions(t *testing.T) {
+	logs := captureLog(t)
+	objects := []RemoteObject{{Key: "b.mp4"}, {Key: "a.mp4"}, {Key: "sub/a.mp4"}}
+
+	kept := keysOf(resolveCaseCollisions(objects, false))
+	if want := []string{"a.mp4", "b.mp4", "sub/a.mp4"}; !slices.Equal(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("unexpected log output: %q", logs.String())
+	}
+}
+
+func TestPartialTarget(t *testing.T) {
+	local := filepath.Join("media", "promo", "clip.mp4")
+	if target, ok := partialTarget(partialPath(local, `"etag"`)); !ok || target != local {
+		t.Errorf("partialTarget(partialPath(%q)) = %q, %v", local, target, ok)
+	}
+	for _, path := range []string{local, local + ".tmp", local + ".upload.tmp", local + ".0000000g.tmp"} {
+		if target, ok := partialTarget(path); ok {
+			t.Errorf("partialTarget(%q) = %q, want no partial", path, target)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	tickerFileName = ".ticker.json"
+	maxTickerRunes = 500
+)
+
+type tickerMessage struct {
+	Text string `json:"text"`
+}
+
+// loadTicker reads the persisted ticker message from dir, an empty message
+// if none was saved yet
+func loadTicker(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, tickerFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var message tickerMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return "", err
+	}
+	return message.Text, nil
+}
+
+// saveTicker persists the ticker message, replacing the file atomically
+func saveTicker(dir, text string) error {
+	data, err := json.Marshal(tickerMessage{Text: text})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, tickerFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// setTicker persists the message and pushes it to the displays
+func (s *Server) setTicker(text string) error {
+	if err := saveTicker(s.config.MediaDir, text); err != nil {
+		return err
+	}
+
+	s.stateMu.Lock()
+	s.ticker = text
+	s.stateMu.Unlock()
+	s.events.publish(eventTickerChanged)
+	return nil
+}
+
+// handleTickerAPI returns the scrolling message on GET and replaces it on
+// PUT with a {"text": "..."} body. An empty text hides the ticker.
+func (s *Server) handleTickerAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		var message tickerMessage
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&message); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		text := strings.TrimSpace(message.Text)
+		if utf8.RuneCountInString(text) > maxTickerRunes {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("text is longer than %d characters", maxTickerRunes))
+			return
+		}
+		if err := s.setTicker(text); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.stateMu.Lock()
+	text := s.ticker
+	s.stateMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tickerMessage{Text: text})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// unsafeFileChars are replaced in uploaded file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sanitizeFileName reduces an uploaded name to a plain, visible file name
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = unsafeFileChars.ReplaceAllString(name, "_")
+	return strings.TrimLeft(name, ".")
+}
+
+// handleUploadAPI stores the "file" part of a multipart POST in MediaDir and
+// responds with the new MediaFile. Like resync it needs API credentials.
+func (s *Server) handleUploadAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !s.authConfigured() {
+		writeJSONError(w, http.StatusForbidden, "no API credentials are configured")
+		return
+	}
+
+	// Leave some room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxUploadBytes+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "expected a multipart/form-data body")
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			writeJSONError(w, http.StatusBadRequest, "missing file part")
+			return
+		}
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		media, status, err := s.storeUpload(part)
+		part.Close()
+		if err != nil {
+			writeJSONError(w, status, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(media)
+		return
+	}
+}
+
+// storeUpload writes one uploaded file through a temporary file, returning
+// the HTTP status to report if it fails
+func (s *Server) storeUpload(part *multipart.Part) (MediaFile, int, error) {
+	fileName := sanitizeFileName(part.FileName())
+	if fileName == "" {
+		return MediaFile{}, http.StatusBadRequest, errors.New("missing file name")
+	}
+	if _, ok := s.config.MediaExtensions[strings.ToLower(filepath.Ext(fileName))]; !ok {
+		return MediaFile{}, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported file type %q", filepath.Ext(fileName))
+	}
+
+	localPath := filepath.Join(s.config.MediaDir, fileName)
+	tmpPath := localPath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return MediaFile{}, http.StatusInternalServerError, err
+	}
+	written, err := io.Copy(file, io.LimitReader(part, s.config.MaxUploadBytes+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > s.config.MaxUploadBytes {
+		err = &http.MaxBytesError{Limit: s.config.MaxUploadBytes}
+	}
+	if err == nil {
+		if validateErr := validateMedia(tmpPath, fileName, s.config.ValidateMedia); validateErr != nil {
+			os.Remove(tmpPath)
+			return MediaFile{}, http.StatusUnprocessableEntity, fmt.Errorf("invalid media file: %w", validateErr)
+		}
+		err = os.Rename(tmpPath, localPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return MediaFile{}, http.StatusRequestEntityTooLarge, fmt.Errorf("file is larger than %d bytes", s.config.MaxUploadBytes)
+		}
+		return MediaFile{}, http.StatusInternalServerError, err
+	}
+
+	log.Printf("Uploaded %s (%d bytes)", fileName, written)
+	if s.source != nil && s.config.DeleteOrphans {
+		log.Printf("Warning: %s is not in %s, the next sync will remove it", fileName, s.source)
+	}
+	s.scanMedia()
+	media, ok := s.findMedia(fileName)
+	if !ok {
+		return MediaFile{}, http.StatusInternalServerError, errors.New("uploaded file was not found by the media scan")
+	}
+	return media, 0, nil
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "upload is too large")
+		return
+	}
+	writeJSONError(w, http.StatusBadRequest, err.Error())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mediaSignatures lists the magic bytes a file of each extension starts
+// with, any one of them is accepted. A signature may skip leading bytes with
+// an offset, e.g. the box size in front of an MP4 "ftyp".
+var mediaSignatures = map[string][]mediaSignature{
+	".mp4":  isoMediaSignatures,
+	".m4v":  isoMediaSignatures,
+	".mov":  isoMediaSignatures,
+	".3gp":  isoMediaSignatures,
+	".mkv":  {{0, "\x1a\x45\xdf\xa3"}},
+	".webm": {{0, "\x1a\x45\xdf\xa3"}},
+	".avi":  {{8, "AVI "}},
+	".jpg":  {{0, "\xff\xd8\xff"}},
+	".jpeg": {{0, "\xff\xd8\xff"}},
+	".png":  {{0, "\x89PNG\r\n\x1a\n"}},
+	".gif":  {{0, "GIF87a"}, {0, "GIF89a"}},
+	".webp": {{8, "WEBP"}},
+	".m3u8": {{0, "#EXTM3U"}},
+}
+
+// QuickTime files may start with any top level atom, not only ftyp
+var isoMediaSignatures = []mediaSignature{
+	{4, "ftyp"}, {4, "moov"}, {4, "mdat"}, {4, "wide"}, {4, "free"}, {4, "skip"},
+}
+
+type mediaSignature struct {
+	offset int
+	magic  string
+}
+
+// validateMedia rejects empty files and, if checkHeader is set, files whose
+// header doesn't match their extension. Files with other extensions, like
+// playlist.json, are not checked.
+func validateMedia(path, name string, checkHeader bool) error {
+	signatures, ok := mediaSignatures[strings.ToLower(filepath.Ext(name))]
+	if !ok {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := make([]byte, 16)
+	n, err := io.ReadFull(file, header)
+	if n == 0 {
+		return errors.New("file is empty")
+	}
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if !checkHeader {
+		return nil
+	}
+
+	header = header[:n]
+	for _, sig := range signatures {
+		end := sig.offset + len(sig.magic)
+		if end <= len(header) && bytes.Equal(header[sig.offset:end], []byte(sig.magic)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("header doesn't look like a %s file", strings.TrimPrefix(filepath.Ext(name), "."))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type volumeSetting struct {
+	Volume *float64 `json:"volume"`
+}
+
+// handleVolumeAPI returns the display volume on GET and sets it on PUT with
+// a {"volume": 0.5} body. The level stays in effect until a restart and is
+// multiplied by a file's volume from playlist.json. It has no effect while
+// MUTED is set.
+func (s *Server) handleVolumeAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		var setting volumeSetting
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&setting); err != nil || setting.Volume == nil {
+			writeJSONError(w, http.StatusBadRequest, "expected a JSON body with a volume")
+			return
+		}
+		if *setting.Volume < 0 || *setting.Volume > 1 {
+			writeJSONError(w, http.StatusBadRequest, "volume must be between 0 and 1")
+			return
+		}
+
+		s.stateMu.Lock()
+		s.volume = *setting.Volume
+		s.stateMu.Unlock()
+		s.events.publish(eventVolumeChanged)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.stateMu.Lock()
+	volume := s.volume
+	s.stateMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"volume": volume,
+		"muted":  s.config.Muted,
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// watchDebounce groups the burst of events from a sync or a copy into a
+	// single rescan
+	watchDebounce = 2 * time.Second
+	// mediaScanInterval is how often the directories are rescanned when
+	// they can't be watched
+	mediaScanInterval = time.Minute
+)
+
+// watchMedia rescans the media directories whenever something in them
+// changes, which pushes the new list to the displays. Without a watcher it
+// falls back to rescanning on a timer.
+func (s *Server) watchMedia(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		for _, dir := range s.config.MediaDirs {
+			if err = addWatchTree(watcher, dir); err != nil {
+				watcher.Close()
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to watch media directories, rescanning every %v: %v", mediaScanInterval, err)
+		s.pollMedia(ctx)
+		return
+	}
+	defer watcher.Close()
+
+	var rescan <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !watchedEvent(event) {
+				continue
+			}
+			// fsnotify doesn't recurse, new directories are added as they appear
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := addWatchTree(watcher, event.Name); err != nil {
+						log.Printf("Failed to watch %s: %v", event.Name, err)
+					}
+				}
+			}
+			rescan = time.After(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Media watcher error: %v", err)
+		case <-rescan:
+			rescan = nil
+			s.scanMedia()
+		}
+	}
+}
+
+func (s *Server) pollMedia(ctx context.Context) {
+	ticker := time.NewTicker(mediaScanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.scanMedia()
+		}
+	}
+}
+
+// addWatchTree watches dir and every directory below it
+func addWatchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// watchedEvent reports whether the event can change the media list. The
+// server's own state files and in-progress downloads are ignored.
+func watchedEvent(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Base(event.Name)
+	return !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, ".tmp")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookRetries is how many more times a failed webhook call is made, a
+// second apart and then doubling
+const webhookRetries = 2
+
+// notifyWebhook posts the sync result as JSON to the configured webhook. It
+// runs in its own goroutine, so the retries don't hold up the sync.
+func (s *Server) notifyWebhook(result SyncResult) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Failed to encode webhook payload: %v", err)
+		return
+	}
+
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := postWebhook(s.config.WebhookURL, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= webhookRetries {
+			log.Printf("Webhook failed: %v", err)
+			return
+		}
+		log.Printf("Webhook failed, retrying in %v: %v", delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postWebhook makes a single webhook call. Client errors other than 429 are
+// not worth retrying, the same payload would be rejected again.
+func postWebhook(url string, body []byte) (retry bool, err error) {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}